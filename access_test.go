@@ -0,0 +1,69 @@
+package githttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLayeredAccess(t *testing.T) {
+	defer os.RemoveAll("./testdata/access")
+
+	var resolved []string
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/access/server",
+		UploadPack:  true,
+		ReceivePack: false,
+		AccessResolver: func(repo string, service string, r *http.Request) (bool, error) {
+			resolved = append(resolved, repo+" "+service)
+			return strings.HasSuffix(repo, "/resolved"), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range []string{"config", "resolved", "denied"} {
+		if _, err := git.CreateRepo(repo, CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := git.SetRepoConfig("config", "http.receivepack", "yes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.SetRepoConfig("config", "http.uploadpack", "false"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	// The repository's config takes precedence over the resolver
+	if out, err := pushTestRepo("./testdata/access/client", server.URL+"/config"); err != nil {
+		t.Errorf("push to repository with http.receivepack failed: %v\n%s", err, out)
+	}
+	res, err := http.Get(server.URL + "/config" + gitRefs + uploadPack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); strings.Contains(ct, "advertisement") {
+		t.Errorf("upload-pack of repository with http.uploadpack=false was advertised: %s", ct)
+	}
+	for _, r := range resolved {
+		if strings.HasPrefix(r, "/config ") {
+			t.Errorf("resolver was asked for %s", r)
+		}
+	}
+
+	// The resolver decides for repositories without config
+	if out, err := runGit("./testdata/access/client", "push", server.URL+"/resolved", "master"); err != nil {
+		t.Errorf("push to resolved repository failed: %v\n%s", err, out)
+	}
+	if out, err := runGit("./testdata/access/client", "push", server.URL+"/denied", "master"); err == nil {
+		t.Errorf("push to denied repository succeeded:\n%s", out)
+	}
+	if len(resolved) == 0 || resolved[0] != "/resolved receive-pack" {
+		t.Errorf("unexpected resolver calls %v", resolved)
+	}
+}
@@ -0,0 +1,420 @@
+// Package admin serves a JSON API to manage the repositories of a git server.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofunky/githttp"
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+const (
+	// defaultLimit is the page size of listings if none was requested
+	defaultLimit = 100
+	// maxLimit is the largest page size of listings
+	maxLimit = 1000
+	// actionSeparator separates repository paths from actions in request paths
+	actionSeparator = "/-/"
+)
+
+// createRequest is the body of a repository creation request
+type createRequest struct {
+	Path string `json:"path"`
+	githttp.CreateOptions
+}
+
+// moveRequest is the body of a rename, move, fork or redirect request
+type moveRequest struct {
+	To string `json:"to"`
+}
+
+// stateRequest is the body of a state change request
+type stateRequest struct {
+	State githttp.RepoState `json:"state"`
+}
+
+// mirrorRequest is the body of a push mirror creation request
+type mirrorRequest struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// restoreRequest is the body of a ref restore request
+type restoreRequest struct {
+	ID string `json:"id"`
+}
+
+// configValue is the body of config requests and responses
+type configValue struct {
+	Value string `json:"value"`
+}
+
+// listResponse is a page of repositories
+type listResponse struct {
+	Repos  []string `json:"repos"`
+	Total  int      `json:"total"`
+	Offset int      `json:"offset"`
+	Limit  int      `json:"limit"`
+}
+
+// Handler serves the repository management API. Paths are resolved like the paths of git requests,
+// i.e. below the project root and by the Preprocesser.
+// It must be wrapped by an authenticator that only admits administrators.
+//
+//	GET    /repos?offset=0&limit=100        lists the repositories
+//	POST   /repos                           creates a repository
+//	GET    /repos/{repo}                    describes a repository
+//	DELETE /repos/{repo}                    deletes a repository
+//	POST   /repos/{repo}/-/move             renames or moves a repository
+//	POST   /repos/{repo}/-/fork             forks a repository
+//	PUT    /repos/{repo}/-/state            changes the state, i.e. active, read-only or archived
+//	POST   /repos/{repo}/-/maintenance      repacks and prunes a repository right away
+//	GET    /repos/{repo}/-/mirrors          lists the push mirrors and their replication status
+//	POST   /repos/{repo}/-/mirrors          adds a push mirror
+//	DELETE /repos/{repo}/-/mirrors/{name}   removes a push mirror
+//	GET    /repos/{repo}/-/history/{ref}    lists the recorded updates of a ref, the most recent first
+//	POST   /repos/{repo}/-/history/{ref}    restores a ref to a value of its history
+//	GET    /repos/{repo}/-/config/{key}     returns a git config value
+//	PUT    /repos/{repo}/-/config/{key}     sets a git config value
+//	DELETE /repos/{repo}/-/config/{key}     removes a git config value
+//	GET    /redirects                       lists the redirects from old to new repository paths
+//	PUT    /redirects/{repo}                redirects an old repository path
+//	DELETE /redirects/{repo}                removes a redirect
+//	GET    /trash                           lists the deleted repositories that can be restored
+//	POST   /trash/{id}/restore              restores a deleted repository
+//	DELETE /trash/{id}                      deletes a repository from the trash irrevocably
+type Handler struct {
+	git githttp.GitHTTP
+}
+
+// NewHandler creates the management API of a git server.
+func NewHandler(git githttp.GitHTTP) *Handler {
+	return &Handler{git: git}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.Trim(r.URL.Path, "/")
+	switch {
+	case p == "repos":
+		h.serveRepos(w, r)
+	case strings.HasPrefix(p, "repos/"):
+		repo, action := strings.TrimPrefix(p, "repos/"), ""
+		if i := strings.Index(repo, actionSeparator); i >= 0 {
+			repo, action = repo[:i], repo[i+len(actionSeparator):]
+		}
+		h.serveRepo(w, r, repo, action)
+	case p == "redirects" && r.Method == "GET":
+		redirects, err := h.git.Redirects()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, 200, redirects)
+	case strings.HasPrefix(p, "redirects/"):
+		h.serveRedirect(w, r, strings.TrimPrefix(p, "redirects/"))
+	case p == "redirects":
+		http.Error(w, "Method Not Allowed", 405)
+	case p == "trash" && r.Method == "GET":
+		trashed, err := h.git.TrashedRepos()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, 200, trashed)
+	case strings.HasPrefix(p, "trash/"):
+		h.serveTrash(w, r, strings.TrimPrefix(p, "trash/"))
+	case p == "trash":
+		http.Error(w, "Method Not Allowed", 405)
+	default:
+		http.Error(w, "Not Found", 404)
+	}
+}
+
+func (h *Handler) serveRepos(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		offset, limit, err := pagination(r)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		repos, err := h.git.ListRepos()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		page := listResponse{Repos: []string{}, Total: len(repos), Offset: offset, Limit: limit}
+		if offset < len(repos) {
+			end := offset + limit
+			if end > len(repos) {
+				end = len(repos)
+			}
+			page.Repos = repos[offset:end]
+		}
+		writeJSON(w, 200, page)
+	case "POST":
+		var req createRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		req.Path = strings.Trim(req.Path, "/")
+		req.Creator = githttp.RequestIdentity(r)
+		if _, err := h.git.CreateRepo(req.Path, req.CreateOptions); err != nil {
+			writeError(w, err)
+			return
+		}
+		h.writeRepo(w, 201, req.Path)
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+}
+
+func (h *Handler) serveRepo(w http.ResponseWriter, r *http.Request, repo string, action string) {
+	switch {
+	case action == "" && r.Method == "GET":
+		h.writeRepo(w, 200, repo)
+	case action == "" && r.Method == "DELETE":
+		if err := h.git.DeleteRepo(repo); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(204)
+	case action == "move" && r.Method == "POST":
+		var req moveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		req.To = strings.Trim(req.To, "/")
+		if err := h.git.MoveRepo(repo, req.To); err != nil {
+			writeError(w, err)
+			return
+		}
+		h.writeRepo(w, 200, req.To)
+	case action == "fork" && r.Method == "POST":
+		var req moveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		req.To = strings.Trim(req.To, "/")
+		if _, err := h.git.ForkRepo(repo, req.To); err != nil {
+			writeError(w, err)
+			return
+		}
+		h.writeRepo(w, 201, req.To)
+	case action == "state" && r.Method == "PUT":
+		var req stateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := h.git.SetRepoState(repo, req.State, githttp.RequestIdentity(r)); err != nil {
+			writeError(w, err)
+			return
+		}
+		h.writeRepo(w, 200, repo)
+	case action == "maintenance" && r.Method == "POST":
+		if err := h.git.MaintainRepo(repo); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(204)
+	case action == "mirrors" && r.Method == "GET":
+		h.writeMirrors(w, 200, repo)
+	case action == "mirrors" && r.Method == "POST":
+		var req mirrorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := h.git.AddPushMirror(repo, req.Name, req.URL); err != nil {
+			writeError(w, err)
+			return
+		}
+		h.writeMirrors(w, 201, repo)
+	case strings.HasPrefix(action, "mirrors/") && r.Method == "DELETE":
+		if err := h.git.RemovePushMirror(repo, strings.TrimPrefix(action, "mirrors/")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(204)
+	case strings.HasPrefix(action, "history/") && r.Method == "GET":
+		h.writeHistory(w, repo, strings.TrimPrefix(action, "history/"))
+	case strings.HasPrefix(action, "history/") && r.Method == "POST":
+		var req restoreRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		ref := strings.TrimPrefix(action, "history/")
+		if err := h.git.RestoreRef(repo, ref, req.ID, githttp.RequestIdentity(r), r.Header.Get("X-Request-Id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		h.writeHistory(w, repo, ref)
+	case strings.HasPrefix(action, "config/"):
+		h.serveConfig(w, r, repo, strings.TrimPrefix(action, "config/"))
+	case action == "" || action == "move" || action == "fork" || action == "state" || action == "maintenance" ||
+		action == "mirrors" || strings.HasPrefix(action, "mirrors/") || strings.HasPrefix(action, "history/"):
+		http.Error(w, "Method Not Allowed", 405)
+	default:
+		http.Error(w, "Not Found", 404)
+	}
+}
+
+func (h *Handler) serveConfig(w http.ResponseWriter, r *http.Request, repo string, key string) {
+	switch r.Method {
+	case "GET":
+		value, err := h.git.RepoConfig(repo, key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, 200, configValue{value})
+	case "PUT":
+		var req configValue
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := h.git.SetRepoConfig(repo, key, req.Value); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, 200, req)
+	case "DELETE":
+		if err := h.git.UnsetRepoConfig(repo, key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(204)
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+}
+
+func (h *Handler) serveRedirect(w http.ResponseWriter, r *http.Request, from string) {
+	switch r.Method {
+	case "PUT":
+		var req moveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := h.git.SetRedirect(from, req.To); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, 200, req)
+	case "DELETE":
+		if err := h.git.RemoveRedirect(from); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(204)
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+}
+
+func (h *Handler) serveTrash(w http.ResponseWriter, r *http.Request, id string) {
+	switch {
+	case strings.HasSuffix(id, "/restore") && r.Method == "POST":
+		repo, err := h.git.RestoreRepo(strings.TrimSuffix(id, "/restore"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		h.writeRepo(w, 200, repo)
+	case !strings.Contains(id, "/") && r.Method == "DELETE":
+		if err := h.git.PurgeRepo(id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(204)
+	case strings.HasSuffix(id, "/restore") || !strings.Contains(id, "/"):
+		http.Error(w, "Method Not Allowed", 405)
+	default:
+		http.Error(w, "Not Found", 404)
+	}
+}
+
+func (h *Handler) writeRepo(w http.ResponseWriter, code int, repo string) {
+	info, err := h.git.RepoInfo(repo)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, code, info)
+}
+
+func (h *Handler) writeHistory(w http.ResponseWriter, repo string, ref string) {
+	history, err := h.git.RefHistory(repo, ref)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, 200, history)
+}
+
+func (h *Handler) writeMirrors(w http.ResponseWriter, code int, repo string) {
+	mirrors, err := h.git.PushMirrors(repo)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, code, mirrors)
+}
+
+// pagination parses the offset and limit of a listing.
+func pagination(r *http.Request) (int, int, error) {
+	offset, limit := 0, defaultLimit
+	var err error
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errInvalidParameter("offset")
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return 0, 0, errInvalidParameter("limit")
+		}
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return offset, limit, nil
+}
+
+// writeError responds with the status code that matches an error.
+func writeError(w http.ResponseWriter, err error) {
+	code := 500
+	switch err {
+	case githttp.ErrInvalidRepoPath, githttp.ErrInvalidBranch, githttp.ErrInvalidConfigKey, githttp.ErrInvalidState,
+		githttp.ErrInvalidMirrorName, githttp.ErrRedirectLoop:
+		code = 400
+	case gogit.ErrRepositoryNotExists, githttp.ErrConfigNotFound, githttp.ErrMirrorNotFound,
+		githttp.ErrRedirectNotFound, githttp.ErrTrashNotFound, githttp.ErrRefValueNotFound:
+		code = 404
+	case githttp.ErrRepoExists, githttp.ErrRepoHasForks, githttp.ErrRepoBusy, githttp.ErrMirrorExists:
+		code = 409
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+type errInvalidParameter string
+
+func (e errInvalidParameter) Error() string {
+	return "invalid parameter " + string(e)
+}
@@ -0,0 +1,164 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofunky/githttp"
+)
+
+func newTestHandler(t *testing.T) (*Handler, string, func()) {
+	root, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	git, err := githttp.NewGitContext(githttp.GitOptions{ProjectRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(git), root, func() { os.RemoveAll(root) }
+}
+
+func serve(h http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rr
+}
+
+func TestRepoLifecycle(t *testing.T) {
+	h, root, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	rr := serve(h, "POST", "/repos", `{"path": "team/app", "defaultBranch": "main", "description": "The app"}`)
+	if rr.Code != 201 {
+		t.Fatalf("create: got %d %s", rr.Code, rr.Body.String())
+	}
+	var info githttp.RepoInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	want := githttp.RepoInfo{Path: "team/app", Bare: true, DefaultBranch: "main", Description: "The app", State: githttp.StateActive}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+	out, err := exec.Command("git", "--git-dir", filepath.Join(root, "team/app"), "symbolic-ref", "HEAD").Output()
+	if err != nil || string(out) != "refs/heads/main\n" {
+		t.Errorf("unexpected HEAD %q: %v", out, err)
+	}
+
+	if rr := serve(h, "POST", "/repos", `{"path": "team/app"}`); rr.Code != 409 {
+		t.Errorf("duplicate: got %d", rr.Code)
+	}
+	if rr := serve(h, "POST", "/repos", `{"path": "../escape"}`); rr.Code != 400 {
+		t.Errorf("escape: got %d", rr.Code)
+	}
+	if rr := serve(h, "POST", "/repos", `{"path": "team/bad", "defaultBranch": "a..b"}`); rr.Code != 400 {
+		t.Errorf("invalid branch: got %d", rr.Code)
+	}
+	if rr := serve(h, "POST", "/repos", `{"path": "team/work", "bare": false}`); rr.Code != 201 || !strings.Contains(rr.Body.String(), `"bare":false`) {
+		t.Errorf("non-bare: got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Config
+	if rr := serve(h, "PUT", "/repos/team/app/-/config/http.receivepack", `{"value": "true"}`); rr.Code != 200 {
+		t.Errorf("set config: got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(h, "GET", "/repos/team/app/-/config/http.receivepack", ""); rr.Code != 200 || !strings.Contains(rr.Body.String(), `"value":"true"`) {
+		t.Errorf("get config: got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(h, "DELETE", "/repos/team/app/-/config/http.receivepack", ""); rr.Code != 204 {
+		t.Errorf("unset config: got %d", rr.Code)
+	}
+	if rr := serve(h, "GET", "/repos/team/app/-/config/http.receivepack", ""); rr.Code != 404 {
+		t.Errorf("unset config: got %d", rr.Code)
+	}
+	if rr := serve(h, "GET", "/repos/team/app/-/config/invalid", ""); rr.Code != 400 {
+		t.Errorf("invalid key: got %d", rr.Code)
+	}
+
+	// State
+	if rr := serve(h, "PUT", "/repos/team/app/-/state", `{"state": "archived"}`); rr.Code != 200 || !strings.Contains(rr.Body.String(), `"state":"archived"`) {
+		t.Errorf("archive: got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(h, "PUT", "/repos/team/app/-/state", `{"state": "frozen"}`); rr.Code != 400 {
+		t.Errorf("invalid state: got %d", rr.Code)
+	}
+
+	// Move
+	rr = serve(h, "POST", "/repos/team/app/-/move", `{"to": "other/app"}`)
+	if rr.Code != 200 || !strings.Contains(rr.Body.String(), `"path":"other/app"`) {
+		t.Errorf("move: got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(h, "GET", "/repos/team/app", ""); rr.Code != 404 {
+		t.Errorf("moved repo: got %d", rr.Code)
+	}
+	if rr := serve(h, "POST", "/repos/other/app/-/move", `{"to": "team/work"}`); rr.Code != 409 {
+		t.Errorf("move onto existing: got %d", rr.Code)
+	}
+
+	// Fork
+	if rr := serve(h, "POST", "/repos/other/app/-/fork", `{"to": "jane/app"}`); rr.Code != 201 || !strings.Contains(rr.Body.String(), `"parent":"other/app"`) {
+		t.Errorf("fork: got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(h, "DELETE", "/repos/other/app", ""); rr.Code != 409 {
+		t.Errorf("delete parent: got %d", rr.Code)
+	}
+
+	// Delete
+	if rr := serve(h, "DELETE", "/repos/jane/app", ""); rr.Code != 204 {
+		t.Errorf("delete fork: got %d", rr.Code)
+	}
+	if rr := serve(h, "DELETE", "/repos/other/app", ""); rr.Code != 204 {
+		t.Errorf("delete: got %d", rr.Code)
+	}
+	if rr := serve(h, "DELETE", "/repos/other/app", ""); rr.Code != 404 {
+		t.Errorf("delete twice: got %d", rr.Code)
+	}
+	// Empty parents are cleaned up
+	if _, err := os.Stat(filepath.Join(root, "other")); !os.IsNotExist(err) {
+		t.Errorf("parent directory wasn't removed: %v", err)
+	}
+}
+
+func TestListRepos(t *testing.T) {
+	h, _, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	for _, repo := range []string{"a", "b/one", "b/two", "c/d/e"} {
+		if rr := serve(h, "POST", "/repos", `{"path": "`+repo+`"}`); rr.Code != 201 {
+			t.Fatalf("create %s: got %d %s", repo, rr.Code, rr.Body.String())
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"a", "b/one", "b/two", "c/d/e"}},
+		{"?limit=2", []string{"a", "b/one"}},
+		{"?offset=2&limit=2", []string{"b/two", "c/d/e"}},
+		{"?offset=10", []string{}},
+	}
+	for _, tt := range tests {
+		rr := serve(h, "GET", "/repos"+tt.query, "")
+		var page listResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(page.Repos, ",") != strings.Join(tt.want, ",") || page.Total != 4 {
+			t.Errorf("%q: got %+v, want %v", tt.query, page, tt.want)
+		}
+	}
+
+	if rr := serve(h, "GET", "/repos?limit=0", ""); rr.Code != 400 {
+		t.Errorf("invalid limit: got %d", rr.Code)
+	}
+}
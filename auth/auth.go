@@ -1,17 +1,32 @@
 package auth
 
 import (
+	"crypto/x509"
 	"net/http"
 	"regexp"
 	"strings"
+
+	"github.com/gofunky/githttp"
+)
+
+// Authentication schemes reported in AuthInfo.Scheme
+const (
+	SchemeBasic       = "basic"
+	SchemeBearer      = "bearer"
+	SchemeCertificate = "certificate"
 )
 
 type AuthInfo struct {
 	// Usernane or email
 	Username string
-	// Plaintext password or token
+	// Plaintext password or token, the bearer token for SchemeBearer
 	Password string
 
+	// Scheme that was used to authenticate, e.g. SchemeBasic
+	Scheme string
+	// Client certificate the username was mapped from (certificate scheme only)
+	Certificate *x509.Certificate
+
 	// repo component of URL
 	// Usually: "username/repo_name"
 	// But could also be: "some_repo.git"
@@ -20,65 +35,128 @@ type AuthInfo struct {
 	// Are we pushing or fetching ?
 	Push  bool
 	Fetch bool
+
+	// Exact operation of the request
+	Operation Operation
+	// Service of ref advertisements, either "upload-pack" or "receive-pack"
+	Service string
+	// File of dumb protocol reads relative to the repository, e.g. "objects/info/packs"
+	File string
+
+	// IP address of the client, see TrustedProxies
+	ClientIP string
+	// HTTP method and user agent of the request
+	Method    string
+	UserAgent string
+	// The original request
+	Request *http.Request
 }
 
 var (
-	repoNameRegex = regexp.MustCompile("^/?(.*?)/(HEAD|git-upload-pack|git-receive-pack|info/refs|objects/.*)$")
+	repoNameRegex = regexp.MustCompile("^/?(.*?)/(HEAD|git-upload-pack|git-receive-pack|info/refs|info/lfs/.*|objects/.*|bundle)$")
 )
 
+// Verifier resolves the identity behind the credentials of a request.
+// Returning a nil identity without an error denies access.
+type Verifier func(info AuthInfo) (*githttp.Identity, error)
+
+// BoolVerifier adapts an authentication function to a Verifier.
+// Granted requests get an identity with the username and scheme of info.
+func BoolVerifier(authf func(AuthInfo) (bool, error)) Verifier {
+	return func(info AuthInfo) (*githttp.Identity, error) {
+		authenticated, err := authf(info)
+		if err != nil || !authenticated {
+			return nil, err
+		}
+		return &githttp.Identity{
+			Username: info.Username,
+			Scheme:   info.Scheme,
+		}, nil
+	}
+}
+
+// Chain returns a verifier that tries the verifiers in order
+// and returns the first identity one of them resolves.
+func Chain(verifiers ...Verifier) Verifier {
+	return func(info AuthInfo) (*githttp.Identity, error) {
+		for _, verify := range verifiers {
+			identity, err := verify(info)
+			if err != nil || identity != nil {
+				return identity, err
+			}
+		}
+		return nil, nil
+	}
+}
+
 func Authenticator(authf func(AuthInfo) (bool, error)) func(http.Handler) http.Handler {
+	return IdentityAuthenticator(BoolVerifier(authf))
+}
+
+// IdentityAuthenticator authenticates requests with Basic credentials or bearer tokens.
+// The identity returned by verify is stored in the request context,
+// see githttp.RequestIdentity.
+func IdentityAuthenticator(verify Verifier) func(http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-			auth, err := parseAuthHeader(req.Header.Get("Authorization"))
+			info, err := basicInfo(req)
 			if err != nil {
-				w.Header().Set("WWW-Authenticate", `Basic realm="git server"`)
-				http.Error(w, err.Error(), 401)
+				renderUnauthorized(w, err)
 				return
 			}
 
-			// Build up info from request headers and URL
-			info := AuthInfo{
-				Username: auth.Name,
-				Password: auth.Pass,
-				Repo:     repoName(req.URL.Path),
-				Push:     isPush(req),
-				Fetch:    isFetch(req),
-			}
+			serveAuthorized(w, req, info, verify, handler)
+		})
+	}
+}
 
-			// Call authentication function
-			authenticated, err := authf(info)
-			if err != nil {
-				code := 500
-				msg := err.Error()
-				if se, ok := err.(StatusError); ok {
-					code = se.StatusCode()
-				}
-				http.Error(w, msg, code)
-				return
-			}
+// basicInfo builds up info from the Basic or Bearer authorization header and the URL
+func basicInfo(req *http.Request) (AuthInfo, error) {
+	info := requestInfo(req)
 
-			// Deny access to repo
-			if !authenticated {
-				http.Error(w, "Forbidden", 403)
-				return
-			}
+	if token, ok := parseBearerHeader(req.Header.Get("Authorization")); ok {
+		info.Password = token
+		info.Scheme = SchemeBearer
+		return info, nil
+	}
 
-			// Access granted
-			handler.ServeHTTP(w, req)
-		})
+	auth, err := parseAuthHeader(req.Header.Get("Authorization"))
+	if err != nil {
+		return AuthInfo{}, err
 	}
+	info.Username = auth.Name
+	info.Password = auth.Pass
+	info.Scheme = SchemeBasic
+	return info, nil
 }
 
-func isFetch(req *http.Request) bool {
-	return isService("upload-pack", req)
-}
+// serveAuthorized calls the verifier and passes the request
+// on to handler with the resolved identity if access was granted
+func serveAuthorized(w http.ResponseWriter, req *http.Request, info AuthInfo, verify Verifier, handler http.Handler) {
+	identity, err := verify(info)
+	if err != nil {
+		code := 500
+		msg := err.Error()
+		if se, ok := err.(StatusError); ok {
+			code = se.StatusCode()
+		}
+		http.Error(w, msg, code)
+		return
+	}
+
+	// Deny access to repo
+	if identity == nil {
+		http.Error(w, "Forbidden", 403)
+		return
+	}
 
-func isPush(req *http.Request) bool {
-	return isService("receive-pack", req)
+	// Access granted
+	handler.ServeHTTP(w, req.WithContext(githttp.WithIdentity(req.Context(), identity)))
 }
 
-func isService(service string, req *http.Request) bool {
-	return getServiceType(req) == service || strings.HasSuffix(req.URL.Path, service)
+func renderUnauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="git server"`)
+	http.Error(w, err.Error(), 401)
 }
 
 func repoName(urlPath string) string {
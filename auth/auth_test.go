@@ -1,7 +1,11 @@
 package auth
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/gofunky/githttp"
 )
 
 func TestRepoName(t *testing.T) {
@@ -13,3 +17,31 @@ func TestRepoName(t *testing.T) {
 		t.Errorf("Should have been 'aarono/gogo-proxy' is '%s'", x)
 	}
 }
+
+func TestIdentityAuthenticator(t *testing.T) {
+	var got *githttp.Identity
+	handler := IdentityAuthenticator(func(info AuthInfo) (*githttp.Identity, error) {
+		if info.Username != "admin" {
+			return nil, nil
+		}
+		return &githttp.Identity{Username: info.Username, Groups: []string{"admins"}, Scheme: info.Scheme}, nil
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = githttp.RequestIdentity(r)
+	}))
+
+	req := httptest.NewRequest("GET", "/repo/info/refs?service=git-upload-pack", nil)
+	req.SetBasicAuth("admin", "password")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != 200 || got == nil || got.Username != "admin" || !got.InGroup("admins") || got.Scheme != SchemeBasic {
+		t.Errorf("got %d, %+v", rr.Code, got)
+	}
+
+	got = nil
+	req.SetBasicAuth("guest", "password")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != 403 || got != nil {
+		t.Errorf("got %d, %+v, want 403", rr.Code, got)
+	}
+}
@@ -45,3 +45,12 @@ func parseAuthHeader(header string) (*BasicAuth, error) {
 		Pass: matches[2],
 	}, nil
 }
+
+// Parse http bearer header
+func parseBearerHeader(header string) (string, bool) {
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) < 2 || strings.ToLower(parts[0]) != "bearer" || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/gofunky/githttp"
+)
+
+// CachePolicy configures a Cache.
+type CachePolicy struct {
+	// How long granted requests are cached, not at all if zero
+	PositiveTTL time.Duration
+	// How long denied requests are cached, not at all if zero
+	NegativeTTL time.Duration
+	// Upper bound of cached results, 10000 if zero
+	MaxEntries int
+}
+
+// cacheEntry is a cached verification result, a nil identity for denials.
+type cacheEntry struct {
+	identity *githttp.Identity
+	expires  time.Time
+}
+
+// Cache caches the results of a verifier, so that the requests of a single git operation
+// (e.g. info/refs and git-upload-pack of a clone) only verify the credentials once.
+// Results are keyed by a hash of the credentials, the client IP, the repository and the kind of access,
+// i.e. smart fetches, smart pushes or the other operations each. Verifiers that decide by other request details,
+// e.g. headers of AuthInfo.Request, must not be cached.
+type Cache struct {
+	policy CachePolicy
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// NewCache creates a cache with the given policy.
+func NewCache(policy CachePolicy) *Cache {
+	if policy.MaxEntries == 0 {
+		policy.MaxEntries = 10000
+	}
+	return &Cache{
+		policy:  policy,
+		now:     time.Now,
+		entries: map[string]cacheEntry{},
+	}
+}
+
+// Verifier wraps verify with the cache. Errors are never cached.
+func (c *Cache) Verifier(verify Verifier) Verifier {
+	return func(info AuthInfo) (*githttp.Identity, error) {
+		key := cacheKey(info)
+		if identity, ok := c.get(key); ok {
+			return identity, nil
+		}
+
+		identity, err := verify(info)
+		if err != nil {
+			return nil, err
+		}
+
+		ttl := c.policy.NegativeTTL
+		if identity != nil {
+			ttl = c.policy.PositiveTTL
+		}
+		if ttl > 0 {
+			c.put(key, cacheEntry{identity: identity, expires: c.now().Add(ttl)})
+		}
+		return copyIdentity(identity), nil
+	}
+}
+
+// Invalidate drops all cached results.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]cacheEntry{}
+}
+
+// InvalidateUser drops the cached results of a user, e.g. after a password change.
+func (c *Cache) InvalidateUser(username string) {
+	c.invalidate(func(identity *githttp.Identity) bool {
+		return identity.Username == username
+	})
+}
+
+// InvalidateToken drops the cached results of a token or deploy key, e.g. after it was revoked.
+func (c *Cache) InvalidateToken(id string) {
+	c.invalidate(func(identity *githttp.Identity) bool {
+		return identity.TokenID == id
+	})
+}
+
+func (c *Cache) invalidate(match func(identity *githttp.Identity) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.identity != nil && match(entry.identity) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *Cache) get(key string) (*githttp.Identity, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return copyIdentity(entry.identity), true
+}
+
+func (c *Cache) put(key string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.policy.MaxEntries {
+		// Drop expired results first, and everything if that didn't help
+		now := c.now()
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.policy.MaxEntries {
+			c.entries = map[string]cacheEntry{}
+		}
+	}
+	c.entries[key] = entry
+}
+
+// cacheKey hashes the credentials, client IP, repository and kind of access of a request.
+// The requests of a clone or push share a key.
+func cacheKey(info AuthInfo) string {
+	h := sha256.New()
+	for _, part := range []string{info.Scheme, info.Username, info.Password, info.ClientIP, info.Repo, accessKind(info)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	if info.Certificate != nil {
+		h.Write([]byte(CertFingerprint(info.Certificate)))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// accessKind normalizes the operation of a request. The ref advertisement and the RPC
+// of a smart fetch or push share their kind, all other operations are their own kind.
+func accessKind(info AuthInfo) string {
+	switch {
+	case info.Operation == OpReceivePack || (info.Operation == OpAdvertise && info.Service == "receive-pack"):
+		return "write"
+	case info.Operation == OpUploadPack || (info.Operation == OpAdvertise && info.Service == "upload-pack"):
+		return "read"
+	}
+	return string(info.Operation)
+}
+
+func copyIdentity(identity *githttp.Identity) *githttp.Identity {
+	if identity == nil {
+		return nil
+	}
+	c := *identity
+	return &c
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofunky/githttp"
+)
+
+func TestCache(t *testing.T) {
+	cache := NewCache(CachePolicy{PositiveTTL: time.Minute, NegativeTTL: time.Second})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	calls := 0
+	verify := cache.Verifier(func(info AuthInfo) (*githttp.Identity, error) {
+		calls++
+		if info.Password != "secret" {
+			return nil, nil
+		}
+		return &githttp.Identity{Username: info.Username}, nil
+	})
+
+	advertise := AuthInfo{Username: "jane", Password: "secret", Repo: "app", Operation: OpAdvertise, Service: "upload-pack", Fetch: true}
+	for i := 0; i < 3; i++ {
+		if identity, err := verify(advertise); identity == nil || err != nil {
+			t.Fatalf("got %v, %v", identity, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+
+	// The requests of a clone share the result
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/app/info/refs?service=git-upload-pack", nil),
+		httptest.NewRequest("POST", "/app/git-upload-pack", nil),
+	} {
+		info := requestInfo(req)
+		info.Username, info.Password, info.ClientIP = "jane", "secret", ""
+		verify(info)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+
+	// Other repositories, kinds of access, client IPs and credentials are cached separately
+	verify(AuthInfo{Username: "jane", Password: "secret", Repo: "other", Operation: OpAdvertise, Service: "upload-pack", Fetch: true})
+	verify(AuthInfo{Username: "jane", Password: "secret", Repo: "app", Operation: OpAdvertise, Service: "receive-pack", Push: true})
+	verify(AuthInfo{Username: "jane", Password: "secret", Repo: "app", Operation: OpUploadPack, Fetch: true, ClientIP: "203.0.113.7"})
+	if calls != 4 {
+		t.Errorf("got %d calls, want 4", calls)
+	}
+	wrong := AuthInfo{Username: "jane", Password: "guess", Repo: "app", Operation: OpAdvertise, Service: "upload-pack"}
+	if identity, _ := verify(wrong); identity != nil {
+		t.Errorf("wrong password was granted")
+	}
+	verify(wrong)
+	if calls != 5 {
+		t.Errorf("got %d calls, want 5", calls)
+	}
+
+	// Negative results expire first
+	now = now.Add(2 * time.Second)
+	verify(wrong)
+	verify(advertise)
+	if calls != 6 {
+		t.Errorf("got %d calls, want 6", calls)
+	}
+
+	cache.InvalidateUser("jane")
+	verify(advertise)
+	if calls != 7 {
+		t.Errorf("got %d calls, want 7", calls)
+	}
+}
+
+func TestCacheDumbRead(t *testing.T) {
+	cache := NewCache(CachePolicy{PositiveTTL: time.Minute, NegativeTTL: time.Minute})
+
+	// Allows smart fetches only
+	calls := 0
+	verify := cache.Verifier(func(info AuthInfo) (*githttp.Identity, error) {
+		calls++
+		if info.Operation == OpDumbRead {
+			return nil, nil
+		}
+		return &githttp.Identity{Username: info.Username}, nil
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/app/info/refs?service=git-upload-pack", nil),
+		httptest.NewRequest("GET", "/app/HEAD", nil),
+		httptest.NewRequest("GET", "/app/info/refs", nil),
+	} {
+		info := requestInfo(req)
+		info.Username, info.Password = "jane", "secret"
+		identity, _ := verify(info)
+		if info.Operation == OpDumbRead && identity != nil {
+			t.Errorf("%s: dumb read was granted", req.URL)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2", calls)
+	}
+}
+
+func TestCacheTokenRevocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewTokenStore(filepath.Join(dir, "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache(CachePolicy{PositiveTTL: time.Hour})
+	store.OnRevoke = func(token Token) {
+		cache.InvalidateToken(token.ID)
+	}
+	verify := cache.Verifier(store.Verify)
+
+	token, secret, err := store.Create("jane", "ci", []string{ScopeRepoRead}, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := AuthInfo{Password: secret, Repo: "app", Operation: OpUploadPack, Fetch: true}
+	if identity, _ := verify(info); identity == nil {
+		t.Fatal("token should be valid")
+	}
+	if err := store.Revoke("jane", token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if identity, _ := verify(info); identity != nil {
+		t.Error("revoked token should not be served from the cache")
+	}
+}
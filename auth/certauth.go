@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// CertMapping maps the attributes of a TLS client certificate to identities.
+//
+// Subject names (CommonNames, Emails and URIs) are only trusted if the
+// certificate chain was verified by the TLS server, i.e. the server's
+// tls.Config uses tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert.
+// Fingerprints pin a single certificate and are trusted either way.
+type CertMapping struct {
+	// Hex encoded SHA-256 fingerprints of the DER certificate to identities
+	Fingerprints map[string]string
+
+	// URI SANs to identities, e.g. "spiffe://example.org/ci" to "ci"
+	URIs map[string]string
+
+	// Lower case email SANs to identities
+	Emails map[string]string
+
+	// Subject common names to identities
+	CommonNames map[string]string
+
+	// Map is consulted if none of the mappings above matched
+	Map func(cert *x509.Certificate) (identity string, ok bool)
+
+	// Use Basic authentication if the client did not present a certificate
+	FallbackBasic bool
+}
+
+var (
+	errNoCertificate      = errors.New("no client certificate presented")
+	errUnknownCertificate = errors.New("client certificate is not mapped to an identity")
+)
+
+// Identity returns the identity a certificate maps to.
+// The most specific mapping wins: fingerprint, URI SAN, email SAN, common name, Map.
+// Set verified if the certificate chain was verified.
+func (m CertMapping) Identity(cert *x509.Certificate, verified bool) (string, bool) {
+	if cert == nil {
+		return "", false
+	}
+
+	if id, ok := m.Fingerprints[CertFingerprint(cert)]; ok {
+		return id, true
+	}
+
+	if !verified {
+		return "", false
+	}
+
+	for _, uri := range cert.URIs {
+		if id, ok := m.URIs[uri.String()]; ok {
+			return id, true
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if id, ok := m.Emails[strings.ToLower(email)]; ok {
+			return id, true
+		}
+	}
+	if id, ok := m.CommonNames[cert.Subject.CommonName]; ok {
+		return id, true
+	}
+	if m.Map != nil {
+		return m.Map(cert)
+	}
+
+	return "", false
+}
+
+// CertFingerprint returns the hex encoded SHA-256 fingerprint of a certificate
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// CertAuthenticator authenticates clients by their TLS client certificate.
+// The mapped identity is passed to authf as AuthInfo.Username.
+func CertAuthenticator(mapping CertMapping, authf func(AuthInfo) (bool, error)) func(http.Handler) http.Handler {
+	return CertIdentityAuthenticator(mapping, BoolVerifier(authf))
+}
+
+// CertIdentityAuthenticator is like CertAuthenticator,
+// but stores the identity returned by verify in the request context.
+func CertIdentityAuthenticator(mapping CertMapping, verify Verifier) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			info, err := certInfo(mapping, req)
+			if err == errNoCertificate && mapping.FallbackBasic {
+				info, err = basicInfo(req)
+			}
+			switch {
+			case err == errUnknownCertificate:
+				http.Error(w, err.Error(), 403)
+				return
+			case err == errNoCertificate:
+				http.Error(w, err.Error(), 401)
+				return
+			case err != nil:
+				renderUnauthorized(w, err)
+				return
+			}
+
+			serveAuthorized(w, req, info, verify, handler)
+		})
+	}
+}
+
+// certInfo builds up info from the client certificate and the URL
+func certInfo(mapping CertMapping, req *http.Request) (AuthInfo, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return AuthInfo{}, errNoCertificate
+	}
+
+	cert := req.TLS.PeerCertificates[0]
+	username, ok := mapping.Identity(cert, len(req.TLS.VerifiedChains) > 0)
+	if !ok {
+		return AuthInfo{}, errUnknownCertificate
+	}
+
+	info := requestInfo(req)
+	info.Username = username
+	info.Scheme = SchemeCertificate
+	info.Certificate = cert
+	return info, nil
+}
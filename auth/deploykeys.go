@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofunky/githttp"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/config"
+)
+
+// SchemeDeployKey is the identity scheme of requests authenticated with a deploy key
+const SchemeDeployKey = "deploykey"
+
+// deployKeyPrefix marks the secrets of deploy keys
+const deployKeyPrefix = "ghdk_"
+
+// deployKeySection is the git config section deploy keys are stored in
+const deployKeySection = "githttp-deploykey"
+
+// ErrDeployKeyNotFound is returned for unknown deploy key ids
+var ErrDeployKeyNotFound = errors.New("deploy key not found")
+
+// DeployKey is a credential that is scoped to a single repository.
+type DeployKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Read-write if true, read-only otherwise
+	Write     bool      `json:"write"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DeployKeys manages deploy keys. They are stored as hashes in the git config of their repository,
+// so they are moved and deleted together with it.
+type DeployKeys struct {
+	// Called after a deploy key was revoked, e.g. to invalidate a Cache
+	OnRevoke func(repo string, key DeployKey)
+
+	// Resolves the local directory of a repository path, usually githttp.GitHTTP.RepoDir
+	resolve func(repo string) (string, error)
+
+	// Mutex per repository directory, held while its config is updated
+	locks sync.Map
+}
+
+// NewDeployKeys creates a deploy key manager for the repositories that resolve finds.
+func NewDeployKeys(resolve func(repo string) (string, error)) *DeployKeys {
+	return &DeployKeys{resolve: resolve}
+}
+
+// Create creates a deploy key for a repository and returns it together with its secret.
+// The secret can't be recovered later on.
+func (k *DeployKeys) Create(repo string, name string, write bool) (DeployKey, string, error) {
+	id, err := randomString(9)
+	if err != nil {
+		return DeployKey{}, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return DeployKey{}, "", err
+	}
+	secret = deployKeyPrefix + secret
+
+	key := DeployKey{
+		ID:        id,
+		Name:      name,
+		Write:     write,
+		CreatedAt: time.Now(),
+	}
+	err = k.update(repo, func(section *config.Section) {
+		sub := section.Subsection(key.ID)
+		sub.SetOption("name", key.Name)
+		sub.SetOption("write", strconv.FormatBool(key.Write))
+		sub.SetOption("created", key.CreatedAt.Format(time.RFC3339))
+		sub.SetOption("hash", hashSecret(secret))
+	})
+	if err != nil {
+		return DeployKey{}, "", err
+	}
+	return key, secret, nil
+}
+
+// List returns the deploy keys of a repository.
+func (k *DeployKeys) List(repo string) ([]DeployKey, error) {
+	section, err := k.section(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []DeployKey{}
+	for _, sub := range section.Subsections {
+		keys = append(keys, parseDeployKey(sub))
+	}
+	return keys, nil
+}
+
+// Revoke deletes a deploy key of a repository.
+func (k *DeployKeys) Revoke(repo string, id string) error {
+	var revoked *DeployKey
+	err := k.update(repo, func(section *config.Section) {
+		var kept config.Subsections
+		for _, sub := range section.Subsections {
+			if sub.Name == id {
+				key := parseDeployKey(sub)
+				revoked = &key
+				continue
+			}
+			kept = append(kept, sub)
+		}
+		section.Subsections = kept
+	})
+	if err != nil {
+		return err
+	}
+	if revoked == nil {
+		return ErrDeployKeyNotFound
+	}
+	if k.OnRevoke != nil {
+		k.OnRevoke(repo, *revoked)
+	}
+	return nil
+}
+
+// Verify is a Verifier that accepts deploy key secrets as Basic password or bearer token
+// for git requests to the repository they were created for. Read-only keys can't push.
+func (k *DeployKeys) Verify(info AuthInfo) (*githttp.Identity, error) {
+	if !strings.HasPrefix(info.Password, deployKeyPrefix) || info.Repo == "" || info.Operation == OpAdmin {
+		return nil, nil
+	}
+
+	section, err := k.section(info.Repo)
+	if err == gogit.ErrRepositoryNotExists {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash := []byte(hashSecret(info.Password))
+	for _, sub := range section.Subsections {
+		if subtle.ConstantTimeCompare(hash, []byte(sub.Option("hash"))) != 1 {
+			continue
+		}
+		key := parseDeployKey(sub)
+		if info.Push && !key.Write {
+			return nil, nil
+		}
+		return &githttp.Identity{
+			Username: "deploykey:" + key.Name,
+			Scheme:   SchemeDeployKey,
+			TokenID:  key.ID,
+		}, nil
+	}
+	return nil, nil
+}
+
+// section returns the deploy key section of a repository's config.
+func (k *DeployKeys) section(repo string) (*config.Section, error) {
+	r, err := k.open(repo)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Raw.Section(deployKeySection), nil
+}
+
+// update modifies the deploy key section of a repository's config.
+// Concurrent updates of a repository are serialized, so that none of them gets lost.
+func (k *DeployKeys) update(repo string, modify func(section *config.Section)) error {
+	dir, err := k.resolve(repo)
+	if err != nil {
+		return err
+	}
+	mu, _ := k.locks.LoadOrStore(dir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	r, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	modify(cfg.Raw.Section(deployKeySection))
+	return r.Storer.SetConfig(cfg)
+}
+
+func (k *DeployKeys) open(repo string) (*gogit.Repository, error) {
+	dir, err := k.resolve(repo)
+	if err != nil {
+		return nil, err
+	}
+	return gogit.PlainOpen(dir)
+}
+
+func parseDeployKey(sub *config.Subsection) DeployKey {
+	write, _ := strconv.ParseBool(sub.Option("write"))
+	created, _ := time.Parse(time.RFC3339, sub.Option("created"))
+	return DeployKey{
+		ID:        sub.Name,
+		Name:      sub.Option("name"),
+		Write:     write,
+		CreatedAt: created,
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofunky/githttp"
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+func TestDeployKeys(t *testing.T) {
+	root, err := ioutil.TempDir("", "deploykeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if _, err := gogit.PlainInit(filepath.Join(root, "team/app"), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gogit.PlainInit(filepath.Join(root, "team/other"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	git, err := githttp.NewGitContext(githttp.GitOptions{ProjectRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewDeployKeys(git.RepoDir)
+
+	readKey, readSecret, err := keys.Create("team/app", "mirror", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, writeSecret, err := keys.Create("team/app", "deploy", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		info AuthInfo
+		want bool
+	}{
+		{"read", AuthInfo{Password: readSecret, Repo: "team/app", Fetch: true}, true},
+		{"read-only push", AuthInfo{Password: readSecret, Repo: "team/app", Push: true}, false},
+		{"write push", AuthInfo{Password: writeSecret, Repo: "team/app", Push: true}, true},
+		{"other repo", AuthInfo{Password: writeSecret, Repo: "team/other", Fetch: true}, false},
+		{"missing repo", AuthInfo{Password: writeSecret, Repo: "team/missing", Fetch: true}, false},
+		{"unknown", AuthInfo{Password: deployKeyPrefix + "unknown", Repo: "team/app"}, false},
+	}
+	for _, tt := range tests {
+		identity, err := keys.Verify(tt.info)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if (identity != nil) != tt.want {
+			t.Errorf("%s: got %+v, want %v", tt.name, identity, tt.want)
+		}
+		if identity != nil && identity.Scheme != SchemeDeployKey {
+			t.Errorf("%s: unexpected identity %+v", tt.name, identity)
+		}
+	}
+
+	// The keys are stored in a config that git understands
+	out, err := exec.Command("git", "--git-dir", filepath.Join(root, "team/app"), "config", "--get-regexp", deployKeySection).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git config failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), readKey.ID+".name mirror") || strings.Contains(string(out), readSecret) {
+		t.Errorf("unexpected config:\n%s", out)
+	}
+
+	listed, err := keys.List("team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 {
+		t.Errorf("got %d keys, want 2", len(listed))
+	}
+
+	if err := keys.Revoke("team/app", readKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Revoke("team/app", readKey.ID); err != ErrDeployKeyNotFound {
+		t.Errorf("got %v, want ErrDeployKeyNotFound", err)
+	}
+	if identity, _ := keys.Verify(AuthInfo{Password: readSecret, Repo: "team/app"}); identity != nil {
+		t.Error("revoked key should be rejected")
+	}
+}
+
+func TestDeployKeysConcurrent(t *testing.T) {
+	root, err := ioutil.TempDir("", "deploykeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if _, err := gogit.PlainInit(filepath.Join(root, "team/app"), true); err != nil {
+		t.Fatal(err)
+	}
+	git, err := githttp.NewGitContext(githttp.GitOptions{ProjectRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewDeployKeys(git.RepoDir)
+
+	first, _, err := keys.Create("team/app", "first", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Creations and revocations don't overwrite each other
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := keys.Create("team/app", "ci", false); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := keys.Revoke("team/app", first.ID); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	listed, err := keys.List("team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 10 {
+		t.Errorf("got %d keys, want 10", len(listed))
+	}
+}
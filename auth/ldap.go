@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofunky/githttp"
+	"gopkg.in/ldap.v3"
+)
+
+// SchemeLDAP is the identity scheme of requests authenticated against an LDAP directory
+const SchemeLDAP = "ldap"
+
+// LDAPConfig configures an LDAP authentication backend.
+// Users are either bound by a DN template, or searched with a service account first.
+type LDAPConfig struct {
+	// Server address, e.g. ldap://ldap.example.org or ldaps://ldap.example.org:636
+	URL string
+	// Upgrade ldap:// connections with StartTLS
+	StartTLS bool
+	// TLS configuration for ldaps:// and StartTLS, the server name defaults to the URL's host
+	TLSConfig *tls.Config
+	// Connection and request timeout, 10s if zero
+	Timeout time.Duration
+	// Maximum number of idle pooled connections, 4 if zero
+	MaxConnections int
+
+	// DN template of users, e.g. uid=%s,ou=people,dc=example,dc=org. Users are searched if empty.
+	UserDN string
+	// Service account that searches users and groups, anonymous if empty
+	BindDN       string
+	BindPassword string
+	// Base of the user search
+	BaseDN string
+	// Filter of the user search, e.g. (uid=%s)
+	UserFilter string
+
+	// Base of the group search, groups aren't looked up if empty
+	GroupBaseDN string
+	// Filter of the group search with the user's DN, e.g. (member=%s)
+	GroupFilter string
+	// Attribute that contains the group name, cn if empty
+	GroupAttribute string
+	// Repository permissions of groups, all users are allowed all operations if empty
+	GroupPermissions []GroupPermission
+}
+
+// GroupPermission grants the members of an LDAP group a scope on repositories.
+type GroupPermission struct {
+	Group string
+	// Repository pattern as in path.Match, e.g. team/*, all repositories if empty
+	Repos string
+	// One of ScopeRepoRead, ScopeRepoWrite and ScopeAdmin
+	Scope string
+}
+
+// LDAP verifies Basic credentials by binding to an LDAP directory.
+// Connections are pooled and reused across requests.
+type LDAP struct {
+	config LDAPConfig
+	url    *url.URL
+	pool   chan *ldap.Conn
+}
+
+// NewLDAP creates an LDAP authentication backend.
+func NewLDAP(config LDAPConfig) (*LDAP, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+	if config.UserDN == "" && config.UserFilter == "" {
+		return nil, errors.New("ldap: either UserDN or UserFilter is required")
+	}
+	for _, p := range config.GroupPermissions {
+		if !validScope(p.Scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.MaxConnections == 0 {
+		config.MaxConnections = 4
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = "cn"
+	}
+	return &LDAP{
+		config: config,
+		url:    u,
+		pool:   make(chan *ldap.Conn, config.MaxConnections),
+	}, nil
+}
+
+// Verify is a Verifier that binds as the user with the Basic credentials of a request.
+// The groups of the user are part of the identity and checked against the GroupPermissions.
+func (l *LDAP) Verify(info AuthInfo) (*githttp.Identity, error) {
+	// An empty password would be an unauthenticated bind that always succeeds
+	if info.Scheme != SchemeBasic || info.Username == "" || info.Password == "" {
+		return nil, nil
+	}
+
+	for {
+		conn, pooled, err := l.get()
+		if err != nil {
+			return nil, err
+		}
+		identity, err := l.verify(conn, info)
+		if err != nil && (conn.IsClosing() || ldap.IsErrorWithCode(err, ldap.ErrorNetwork)) {
+			conn.Close()
+			// Pooled connections may have been closed by the server in the meantime
+			if pooled {
+				continue
+			}
+			return nil, err
+		}
+		l.put(conn)
+		return identity, err
+	}
+}
+
+// Close closes all pooled connections.
+func (l *LDAP) Close() {
+	for {
+		select {
+		case conn := <-l.pool:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (l *LDAP) verify(conn *ldap.Conn, info AuthInfo) (*githttp.Identity, error) {
+	userDN, err := l.userDN(conn, info.Username)
+	if err != nil || userDN == "" {
+		return nil, err
+	}
+
+	err = conn.Bind(userDN, info.Password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := l.groups(conn, userDN)
+	if err != nil {
+		return nil, err
+	}
+	if !l.permitted(groups, info) {
+		return nil, nil
+	}
+	return &githttp.Identity{
+		Username: info.Username,
+		Groups:   groups,
+		Scheme:   SchemeLDAP,
+	}, nil
+}
+
+// userDN returns the DN of a user, or an empty string if the user doesn't exist.
+func (l *LDAP) userDN(conn *ldap.Conn, username string) (string, error) {
+	if l.config.UserDN != "" {
+		return fmt.Sprintf(l.config.UserDN, escapeDN(username)), nil
+	}
+
+	if err := l.bindService(conn); err != nil {
+		return "", err
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(l.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn"}, nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	// Ambiguous users are rejected
+	if len(result.Entries) != 1 {
+		return "", nil
+	}
+	return result.Entries[0].DN, nil
+}
+
+// groups returns the names of the groups a user is a member of.
+func (l *LDAP) groups(conn *ldap.Conn, userDN string) ([]string, error) {
+	if l.config.GroupBaseDN == "" || l.config.GroupFilter == "" {
+		return nil, nil
+	}
+	if l.config.BindDN != "" {
+		if err := l.bindService(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(l.config.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{l.config.GroupAttribute}, nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.GetAttributeValues(l.config.GroupAttribute)...)
+	}
+	return groups, nil
+}
+
+func (l *LDAP) bindService(conn *ldap.Conn) error {
+	if l.config.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(l.config.BindDN, l.config.BindPassword)
+}
+
+// permitted returns true if one of the groups grants the scope that the request requires.
+func (l *LDAP) permitted(groups []string, info AuthInfo) bool {
+	if len(l.config.GroupPermissions) == 0 {
+		return true
+	}
+
+	scope := requiredScope(info)
+	for _, p := range l.config.GroupPermissions {
+		if !containsFold(groups, p.Group) || !scopeGrants(p.Scope, scope) {
+			continue
+		}
+		if p.Repos == "" {
+			return true
+		}
+		if ok, _ := path.Match(p.Repos, info.Repo); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// get takes a connection from the pool, or dials a new one.
+func (l *LDAP) get() (*ldap.Conn, bool, error) {
+	select {
+	case conn := <-l.pool:
+		return conn, true, nil
+	default:
+	}
+	conn, err := l.dial()
+	return conn, false, err
+}
+
+// put returns a connection to the pool, or closes it if the pool is full.
+func (l *LDAP) put(conn *ldap.Conn) {
+	select {
+	case l.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (l *LDAP) dial() (*ldap.Conn, error) {
+	host := l.url.Host
+	if l.url.Port() == "" {
+		port := "389"
+		if l.url.Scheme == "ldaps" {
+			port = "636"
+		}
+		host = net.JoinHostPort(l.url.Hostname(), port)
+	}
+
+	tlsConfig := &tls.Config{}
+	if l.config.TLSConfig != nil {
+		tlsConfig = l.config.TLSConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = l.url.Hostname()
+	}
+
+	dialer := &net.Dialer{Timeout: l.config.Timeout}
+	var c net.Conn
+	var err error
+	if l.url.Scheme == "ldaps" {
+		c, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	} else {
+		c, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+
+	conn := ldap.NewConn(c, l.url.Scheme == "ldaps")
+	conn.Start()
+	conn.SetTimeout(l.config.Timeout)
+	if l.config.StartTLS && l.url.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// escapeDN escapes a value for use in a DN as described in RFC 4514.
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			b.WriteRune('\\')
+			b.WriteRune(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v3"
+)
+
+// testLDAPServer is a minimal in-process LDAP server that supports simple binds,
+// searches by exact filter and StartTLS.
+type testLDAPServer struct {
+	listener net.Listener
+	// Passwords by DN
+	users map[string]string
+	// Search results by filter, as attributes by DN
+	results map[string]map[string]map[string][]string
+	// Certificate for StartTLS
+	tls *tls.Config
+
+	mu    sync.Mutex
+	conns int
+}
+
+func newTestLDAPServer(t *testing.T, useTLS bool) (*testLDAPServer, *x509.CertPool) {
+	// Borrow the test certificate of httptest
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testLDAPServer{
+		tls: &tls.Config{Certificates: ts.TLS.Certificates},
+		users: map[string]string{
+			"cn=service,dc=example,dc=org":         "service",
+			"uid=jane,ou=people,dc=example,dc=org": "secret",
+			"uid=john,ou=people,dc=example,dc=org": "secret",
+		},
+		results: map[string]map[string]map[string][]string{
+			"(uid=jane)": {"uid=jane,ou=people,dc=example,dc=org": nil},
+			"(uid=john)": {"uid=john,ou=people,dc=example,dc=org": nil},
+			"(member=uid=jane,ou=people,dc=example,dc=org)": {
+				"cn=developers,ou=groups,dc=example,dc=org": {"cn": {"developers"}},
+				"cn=admins,ou=groups,dc=example,dc=org":     {"cn": {"admins"}},
+			},
+			"(member=uid=john,ou=people,dc=example,dc=org)": {
+				"cn=readers,ou=groups,dc=example,dc=org": {"cn": {"readers"}},
+			},
+		},
+	}
+	if useTLS {
+		listener = tls.NewListener(listener, s.tls)
+	}
+	s.listener = listener
+	go s.serve()
+	return s, pool
+}
+
+func (s *testLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *testLDAPServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			if dn == "" && password == "" || password != "" && s.users[dn] == password {
+				code = ldap.LDAPResultSuccess
+			}
+			s.respond(conn, id, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				s.respond(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultFilterError)
+				continue
+			}
+			for dn, attributes := range s.results[filter] {
+				envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+				list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				for name, values := range attributes {
+					attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, value := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+					}
+					attribute.AppendChild(set)
+					list.AppendChild(attribute)
+				}
+				entry.AppendChild(list)
+				envelope.AppendChild(entry)
+				conn.Write(envelope.Bytes())
+			}
+			s.respond(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+		case ldap.ApplicationExtendedRequest:
+			// StartTLS is the only supported extended operation
+			s.respond(conn, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)
+			conn = tls.Server(conn, s.tls)
+		default:
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) respond(w io.Writer, id int64, tag ber.Tag, code int) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	envelope.AppendChild(response)
+	w.Write(envelope.Bytes())
+}
+
+func (s *testLDAPServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func TestLDAPSearchBind(t *testing.T) {
+	server, _ := newTestLDAPServer(t, false)
+	defer server.listener.Close()
+
+	backend, err := NewLDAP(LDAPConfig{
+		URL:          "ldap://" + server.listener.Addr().String(),
+		BindDN:       "cn=service,dc=example,dc=org",
+		BindPassword: "service",
+		BaseDN:       "ou=people,dc=example,dc=org",
+		UserFilter:   "(uid=%s)",
+		GroupBaseDN:  "ou=groups,dc=example,dc=org",
+		GroupFilter:  "(member=%s)",
+		GroupPermissions: []GroupPermission{
+			{Group: "developers", Repos: "team/*", Scope: ScopeRepoWrite},
+			{Group: "readers", Scope: ScopeRepoRead},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	tests := []struct {
+		name string
+		info AuthInfo
+		want bool
+	}{
+		{"developer push", AuthInfo{Username: "jane", Password: "secret", Repo: "team/app", Push: true}, true},
+		{"developer other repo", AuthInfo{Username: "jane", Password: "secret", Repo: "other/app", Fetch: true}, false},
+		{"reader fetch", AuthInfo{Username: "john", Password: "secret", Repo: "other/app", Fetch: true}, true},
+		{"reader push", AuthInfo{Username: "john", Password: "secret", Repo: "team/app", Push: true}, false},
+		{"wrong password", AuthInfo{Username: "jane", Password: "wrong", Repo: "team/app", Fetch: true}, false},
+		{"empty password", AuthInfo{Username: "jane", Repo: "team/app", Fetch: true}, false},
+		{"unknown user", AuthInfo{Username: "jim", Password: "secret", Repo: "team/app", Fetch: true}, false},
+		{"filter injection", AuthInfo{Username: "*", Password: "secret", Repo: "team/app", Fetch: true}, false},
+	}
+	for _, tt := range tests {
+		tt.info.Scheme = SchemeBasic
+		identity, err := backend.Verify(tt.info)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if (identity != nil) != tt.want {
+			t.Errorf("%s: got %+v, want %v", tt.name, identity, tt.want)
+		}
+		if identity != nil && (identity.Scheme != SchemeLDAP || len(identity.Groups) == 0) {
+			t.Errorf("%s: unexpected identity %+v", tt.name, identity)
+		}
+	}
+
+	// All requests share a pooled connection
+	if n := server.connections(); n != 1 {
+		t.Errorf("got %d connections, want 1", n)
+	}
+}
+
+func TestLDAPTLS(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		scheme   string
+		startTLS bool
+	}{
+		{"ldaps", "ldaps", false},
+		{"starttls", "ldap", true},
+	} {
+		server, pool := newTestLDAPServer(t, tt.scheme == "ldaps")
+		backend, err := NewLDAP(LDAPConfig{
+			URL:       tt.scheme + "://" + server.listener.Addr().String(),
+			StartTLS:  tt.startTLS,
+			TLSConfig: &tls.Config{RootCAs: pool},
+			UserDN:    "uid=%s,ou=people,dc=example,dc=org",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info := AuthInfo{Username: "jane", Password: "secret", Scheme: SchemeBasic, Repo: "team/app"}
+		if identity, err := backend.Verify(info); err != nil || identity == nil || identity.Username != "jane" {
+			t.Errorf("%s: got %+v, %v", tt.name, identity, err)
+		}
+		// DN special characters can't escape the template
+		info.Username = "jane,ou=people,dc=example,dc=org"
+		if identity, err := backend.Verify(info); err != nil || identity != nil {
+			t.Errorf("%s: got %+v, %v", tt.name, identity, err)
+		}
+
+		backend.Close()
+		server.listener.Close()
+	}
+
+	// The server certificate is verified
+	server, _ := newTestLDAPServer(t, true)
+	defer server.listener.Close()
+	backend, err := NewLDAP(LDAPConfig{
+		URL:    "ldaps://" + server.listener.Addr().String(),
+		UserDN: "uid=%s,ou=people,dc=example,dc=org",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Verify(AuthInfo{Username: "jane", Password: "secret", Scheme: SchemeBasic}); err == nil {
+		t.Error("untrusted certificate should fail")
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	tests := map[string]string{
+		"jane":       "jane",
+		"a,b=c":      `a\,b\=c`,
+		" #jane ":    `\ #jane\ `,
+		"#jane":      `\#jane`,
+		`back\slash`: `back\\slash`,
+	}
+	for in, want := range tests {
+		if got := escapeDN(in); got != want {
+			t.Errorf("escapeDN(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofunky/githttp"
+)
+
+// LockoutPolicy configures the brute-force protection of a Lockout.
+type LockoutPolicy struct {
+	// Failed attempts of a username or client IP until it is locked out
+	MaxAttempts int
+
+	// Delay after the first failed attempt, doubled on every further failure
+	BaseDelay time.Duration
+	// Upper bound of the delay
+	MaxDelay time.Duration
+
+	// How long a username or client IP stays locked out
+	LockoutDuration time.Duration
+
+	// Failed attempts older than this are forgotten
+	Window time.Duration
+
+	// Store of the failed attempts, in memory if nil
+	Store LockoutStore
+
+	// Called whenever a username or client IP gets locked out
+	OnLockout func(event LockoutEvent)
+}
+
+// LockoutState is the failed attempt record of a username or client IP.
+type LockoutState struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// LockoutEvent is emitted when a lockout triggers.
+type LockoutEvent struct {
+	// Either "user" or "ip"
+	Kind string
+	// Locked out username or client IP
+	Key string
+	// Failed attempts that triggered the lockout
+	Failures int
+	// End of the lockout
+	Until time.Time
+}
+
+// LockoutStore persists failed attempts, e.g. to share them between server instances.
+type LockoutStore interface {
+	// Update replaces the state of key, a zero state if unknown, by the result of update and returns it.
+	// Concurrent updates of a key must not get lost, update may be called again to retry.
+	Update(key string, update func(state LockoutState) LockoutState) (LockoutState, error)
+	// Delete forgets key
+	Delete(key string) error
+}
+
+// ErrorLockedOut is returned for requests of locked out usernames or client IPs.
+type ErrorLockedOut struct {
+	Until time.Time
+}
+
+func (e *ErrorLockedOut) Error() string {
+	return fmt.Sprintf("too many failed attempts, try again after %s", e.Until.Format(time.RFC3339))
+}
+
+// StatusCode implements the StatusError interface
+func (e *ErrorLockedOut) StatusCode() int {
+	return 429
+}
+
+// Lockout tracks failed authentication attempts per username and client IP.
+// Failed attempts are delayed exponentially and lock out the username
+// or client IP temporarily once they exceed the policy's MaxAttempts.
+type Lockout struct {
+	policy LockoutPolicy
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// NewLockout creates a lockout with the given policy.
+func NewLockout(policy LockoutPolicy) *Lockout {
+	if policy.Store == nil {
+		policy.Store = NewMemoryLockoutStore(policy.Window)
+	}
+	return &Lockout{
+		policy: policy,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Verifier wraps verify with the brute-force protection.
+// Every request that verify denies counts as a failed attempt.
+// Attempts are recorded before verifying them, so that concurrent attempts
+// can't exceed the policy's MaxAttempts.
+func (l *Lockout) Verifier(verify Verifier) Verifier {
+	return func(info AuthInfo) (*githttp.Identity, error) {
+		keys := lockoutKeys(info)
+		now := l.now()
+
+		// Reject locked out requests and delay repeated failures
+		var failures int
+		for i, key := range keys {
+			state, err := l.attempt(key, now)
+			if err == nil && now.Before(state.LockedUntil) {
+				err = &ErrorLockedOut{state.LockedUntil}
+			}
+			if err != nil {
+				for _, reserved := range keys[:i] {
+					l.release(reserved)
+				}
+				return nil, err
+			}
+			if state.Failures-1 > failures {
+				failures = state.Failures - 1
+			}
+		}
+		if delay := l.delay(failures); delay > 0 {
+			l.sleep(delay)
+		}
+
+		identity, err := verify(info)
+		if err != nil || identity != nil {
+			for i, key := range keys {
+				if i == 0 && info.Username != "" && identity != nil {
+					if err := l.policy.Store.Delete(key); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if err := l.release(key); err != nil {
+					return nil, err
+				}
+			}
+			return identity, err
+		}
+
+		for _, key := range keys {
+			if err := l.fail(key, l.now()); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+}
+
+// Unlock forgets the failed attempts of a username.
+func (l *Lockout) Unlock(username string) error {
+	return l.policy.Store.Delete("user:" + username)
+}
+
+// UnlockIP forgets the failed attempts of a client IP.
+func (l *Lockout) UnlockIP(clientIP string) error {
+	return l.policy.Store.Delete("ip:" + clientIP)
+}
+
+// lockoutKeys returns the store keys of the username and client IP of a request.
+func lockoutKeys(info AuthInfo) []string {
+	var keys []string
+	if info.Username != "" {
+		keys = append(keys, "user:"+info.Username)
+	}
+	if info.ClientIP != "" {
+		keys = append(keys, "ip:"+info.ClientIP)
+	}
+	return keys
+}
+
+// attempt counts an attempt of key as failed until it is released, unless key is locked out.
+// Attempts beyond the policy's MaxAttempts lock key out right away.
+func (l *Lockout) attempt(key string, now time.Time) (LockoutState, error) {
+	var event *LockoutEvent
+	state, err := l.policy.Store.Update(key, func(state LockoutState) LockoutState {
+		event = nil
+		state = l.expire(state, now)
+		switch {
+		case now.Before(state.LockedUntil):
+		case l.policy.MaxAttempts > 0 && state.Failures >= l.policy.MaxAttempts:
+			event = l.lock(key, &state, now)
+		default:
+			state.Failures++
+			state.LastFailure = now
+		}
+		return state
+	})
+	if err == nil {
+		l.notify(event)
+	}
+	return state, err
+}
+
+// release takes back an attempt of key that didn't fail.
+func (l *Lockout) release(key string) error {
+	_, err := l.policy.Store.Update(key, func(state LockoutState) LockoutState {
+		if state.Failures > 0 {
+			state.Failures--
+		}
+		return state
+	})
+	return err
+}
+
+// fail records that an attempt of key failed and locks key out once it reaches the policy's MaxAttempts.
+func (l *Lockout) fail(key string, now time.Time) error {
+	var event *LockoutEvent
+	_, err := l.policy.Store.Update(key, func(state LockoutState) LockoutState {
+		event = nil
+		state.LastFailure = now
+		if l.policy.MaxAttempts > 0 && state.Failures >= l.policy.MaxAttempts && !now.Before(state.LockedUntil) {
+			event = l.lock(key, &state, now)
+		}
+		return state
+	})
+	if err == nil {
+		l.notify(event)
+	}
+	return err
+}
+
+// expire returns state with failures older than the policy's Window forgotten.
+func (l *Lockout) expire(state LockoutState, now time.Time) LockoutState {
+	if l.policy.Window > 0 && now.Sub(state.LastFailure) > l.policy.Window && now.After(state.LockedUntil) {
+		return LockoutState{}
+	}
+	return state
+}
+
+// lock locks key out and returns the event to emit.
+func (l *Lockout) lock(key string, state *LockoutState, now time.Time) *LockoutEvent {
+	state.LockedUntil = now.Add(l.policy.LockoutDuration)
+	kind, value := splitLockoutKey(key)
+	event := &LockoutEvent{
+		Kind:     kind,
+		Key:      value,
+		Failures: state.Failures,
+		Until:    state.LockedUntil,
+	}
+	// Start over after the lockout
+	state.Failures = 0
+	return event
+}
+
+// notify emits a lockout event, if any, outside of store updates.
+func (l *Lockout) notify(event *LockoutEvent) {
+	if event != nil && l.policy.OnLockout != nil {
+		l.policy.OnLockout(*event)
+	}
+}
+
+// delay returns the backoff after the given number of failed attempts.
+func (l *Lockout) delay(failures int) time.Duration {
+	if failures == 0 || l.policy.BaseDelay <= 0 {
+		return 0
+	}
+	delay := l.policy.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if l.policy.MaxDelay > 0 && delay >= l.policy.MaxDelay {
+			return l.policy.MaxDelay
+		}
+	}
+	return delay
+}
+
+func splitLockoutKey(key string) (kind string, value string) {
+	parts := strings.SplitN(key, ":", 2)
+	return parts[0], parts[1]
+}
+
+// memoryLockoutStore is the default in-memory LockoutStore.
+type memoryLockoutStore struct {
+	mu      sync.Mutex
+	states  map[string]LockoutState
+	window  time.Duration
+	updates int
+}
+
+// NewMemoryLockoutStore creates a LockoutStore that keeps failed attempts in memory.
+// Failed attempts older than window are swept, like with the Window of a LockoutPolicy.
+func NewMemoryLockoutStore(window time.Duration) LockoutStore {
+	return &memoryLockoutStore{
+		states: map[string]LockoutState{},
+		window: window,
+	}
+}
+
+func (s *memoryLockoutStore) Update(key string, update func(state LockoutState) LockoutState) (LockoutState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := update(s.states[key])
+	s.states[key] = state
+
+	// Sweep stale entries every now and then
+	s.updates++
+	if s.updates%1024 == 0 {
+		now := time.Now()
+		for k, st := range s.states {
+			if !now.Before(st.LockedUntil) && (st.Failures == 0 || (s.window > 0 && now.Sub(st.LastFailure) > s.window)) {
+				delete(s.states, k)
+			}
+		}
+	}
+	return state, nil
+}
+
+func (s *memoryLockoutStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+	return nil
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofunky/githttp"
+)
+
+func TestLockout(t *testing.T) {
+	var events []LockoutEvent
+	lockout := NewLockout(LockoutPolicy{
+		MaxAttempts:     3,
+		BaseDelay:       time.Second,
+		MaxDelay:        3 * time.Second,
+		LockoutDuration: time.Minute,
+		Window:          time.Hour,
+		OnLockout: func(event LockoutEvent) {
+			events = append(events, event)
+		},
+	})
+	now := time.Now()
+	var slept []time.Duration
+	lockout.now = func() time.Time { return now }
+	lockout.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	verify := lockout.Verifier(func(info AuthInfo) (*githttp.Identity, error) {
+		if info.Password != "secret" {
+			return nil, nil
+		}
+		return &githttp.Identity{Username: info.Username}, nil
+	})
+
+	wrong := AuthInfo{Username: "jane", Password: "guess", ClientIP: "10.0.0.1"}
+	for i := 0; i < 3; i++ {
+		if id, err := verify(wrong); id != nil || err != nil {
+			t.Fatalf("attempt %d: got %v, %v", i, id, err)
+		}
+	}
+	if len(slept) != 2 || slept[0] != time.Second || slept[1] != 2*time.Second {
+		t.Errorf("got delays %v, want [1s 2s]", slept)
+	}
+	if len(events) != 2 || events[0].Kind != "user" || events[0].Key != "jane" || events[1].Kind != "ip" {
+		t.Errorf("got lockout events %+v", events)
+	}
+
+	// Locked out even with the right password
+	right := AuthInfo{Username: "jane", Password: "secret", ClientIP: "10.0.0.2"}
+	if _, err := verify(right); err == nil {
+		t.Fatal("user should be locked out")
+	} else if se, ok := err.(StatusError); !ok || se.StatusCode() != 429 {
+		t.Errorf("got %v, want status 429", err)
+	}
+
+	// The lockout expires
+	now = now.Add(2 * time.Minute)
+	if id, err := verify(right); id == nil || err != nil {
+		t.Errorf("lockout should have expired: %v, %v", id, err)
+	}
+
+	// Unlocking an IP
+	if err := lockout.UnlockIP("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := verify(AuthInfo{Username: "john", Password: "secret", ClientIP: "10.0.0.1"}); id == nil || err != nil {
+		t.Errorf("ip should have been unlocked: %v, %v", id, err)
+	}
+}
+
+func TestLockoutConcurrent(t *testing.T) {
+	var events []LockoutEvent
+	lockout := NewLockout(LockoutPolicy{
+		MaxAttempts:     3,
+		BaseDelay:       time.Second,
+		LockoutDuration: time.Minute,
+		OnLockout: func(event LockoutEvent) {
+			events = append(events, event)
+		},
+	})
+	lockout.sleep = func(time.Duration) {}
+
+	// Guesses block until the ones beyond MaxAttempts were rejected
+	var calls int32
+	gate := make(chan struct{})
+	verify := lockout.Verifier(func(info AuthInfo) (*githttp.Identity, error) {
+		atomic.AddInt32(&calls, 1)
+		<-gate
+		return nil, nil
+	})
+
+	const guesses = 20
+	rejected := make(chan error, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := verify(AuthInfo{Username: "jane", Password: "guess"}); err != nil {
+				rejected <- err
+			}
+		}()
+	}
+	timeout := time.After(5 * time.Second)
+wait:
+	for i := 0; i < guesses-3; i++ {
+		select {
+		case err := <-rejected:
+			if _, ok := err.(*ErrorLockedOut); !ok {
+				t.Errorf("got %v, want a lockout", err)
+			}
+		case <-timeout:
+			t.Errorf("only %d guesses were rejected", i)
+			break wait
+		}
+	}
+	close(gate)
+	wg.Wait()
+
+	if calls != 3 {
+		t.Errorf("verified %d guesses, want 3", calls)
+	}
+	if len(events) != 1 || events[0].Key != "jane" {
+		t.Errorf("got lockout events %+v", events)
+	}
+}
+
+func TestLockoutDelay(t *testing.T) {
+	lockout := NewLockout(LockoutPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+	tests := map[int]time.Duration{
+		0: 0,
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+		9: time.Second,
+	}
+	for failures, want := range tests {
+		if got := lockout.delay(failures); got != want {
+			t.Errorf("delay(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Operation is the kind of access a request asks for.
+type Operation string
+
+// Possible operations
+const (
+	// Ref advertisement of the smart protocol (info/refs?service=...)
+	OpAdvertise Operation = "advertise"
+	// Fetch of the smart protocol
+	OpUploadPack Operation = "upload-pack"
+	// Push of the smart protocol
+	OpReceivePack Operation = "receive-pack"
+	// File read of the dumb protocol, e.g. objects/pack/pack-*.pack
+	OpDumbRead Operation = "dumb-read"
+	// Download of a git bundle (GET bundle)
+	OpBundleExport Operation = "bundle-export"
+	// Upload of a git bundle that updates refs (POST bundle)
+	OpBundleImport Operation = "bundle-import"
+	// Git LFS API (info/lfs/...)
+	OpLFS Operation = "lfs"
+	// Any other request, e.g. of a management API behind the authenticator
+	OpAdmin Operation = "admin"
+)
+
+// requestInfo builds up info from the URL and headers of a request
+func requestInfo(req *http.Request) AuthInfo {
+	repo := repoName(req.URL.Path)
+	info := AuthInfo{
+		Repo:      repo,
+		Operation: OpAdmin,
+		ClientIP:  clientIP(req),
+		Method:    req.Method,
+		UserAgent: req.UserAgent(),
+		Request:   req,
+	}
+
+	file := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/"), repo+"/")
+	switch {
+	case !repoNameRegex.MatchString(req.URL.Path):
+		// Not a repository URL
+	case file == "git-upload-pack":
+		info.Operation = OpUploadPack
+	case file == "git-receive-pack":
+		info.Operation = OpReceivePack
+	case file == "bundle" && req.Method == "POST":
+		info.Operation = OpBundleImport
+	case file == "bundle":
+		info.Operation = OpBundleExport
+	case strings.HasPrefix(file, "info/lfs/"):
+		info.Operation = OpLFS
+	case file == "info/refs" && getServiceType(req) != "":
+		info.Operation = OpAdvertise
+		info.Service = getServiceType(req)
+	default:
+		info.Operation = OpDumbRead
+		info.File = file
+	}
+
+	info.Push = info.Operation == OpReceivePack || info.Service == "receive-pack" || info.Operation == OpBundleImport
+	info.Fetch = info.Operation == OpUploadPack || info.Service == "upload-pack" || info.Operation == OpDumbRead ||
+		info.Operation == OpBundleExport
+	return info
+}
+
+type clientIPKey struct{}
+
+// TrustedProxies returns a middleware that takes the client IP of requests
+// from trusted proxies out of their X-Forwarded-For header.
+// Proxies are given as IP addresses or CIDR ranges.
+func TrustedProxies(proxies ...string) (func(http.Handler) http.Handler, error) {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+
+	trusted := func(ip string) bool {
+		parsed := net.ParseIP(ip)
+		for _, ipNet := range nets {
+			if parsed != nil && ipNet.Contains(parsed) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ip := remoteIP(req)
+			if trusted(ip) {
+				// Walk the proxy chain from the nearest hop backwards
+				hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop := strings.TrimSpace(hops[i])
+					if hop == "" {
+						continue
+					}
+					ip = hop
+					if !trusted(hop) {
+						break
+					}
+				}
+			}
+			handler.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), clientIPKey{}, ip)))
+		})
+	}, nil
+}
+
+// clientIP returns the IP address of the client,
+// as resolved by TrustedProxies if it wraps the handler.
+func clientIP(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(req)
+}
+
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
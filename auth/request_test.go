@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestInfo(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+
+		op      Operation
+		service string
+		file    string
+		push    bool
+		fetch   bool
+	}{
+		{"GET", "/team/app/info/refs?service=git-upload-pack", OpAdvertise, "upload-pack", "", false, true},
+		{"GET", "/team/app/info/refs?service=git-receive-pack", OpAdvertise, "receive-pack", "", true, false},
+		{"POST", "/team/app/git-upload-pack", OpUploadPack, "", "", false, true},
+		{"POST", "/team/app/git-receive-pack", OpReceivePack, "", "", true, false},
+		{"GET", "/team/app/info/refs", OpDumbRead, "", "info/refs", false, true},
+		{"GET", "/team/app/HEAD", OpDumbRead, "", "HEAD", false, true},
+		{"GET", "/team/app/objects/pack/pack-0123456789012345678901234567890123456789.pack", OpDumbRead, "",
+			"objects/pack/pack-0123456789012345678901234567890123456789.pack", false, true},
+		{"GET", "/team/app/bundle?ref=main", OpBundleExport, "", "", false, true},
+		{"POST", "/team/app/bundle", OpBundleImport, "", "", true, false},
+		{"POST", "/team/app/info/lfs/objects/batch", OpLFS, "", "", false, false},
+		{"GET", "/api/tokens/", OpAdmin, "", "", false, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		req.Header.Set("User-Agent", "git/2.39.0")
+		info := requestInfo(req)
+		if info.Operation != tt.op || info.Service != tt.service || info.File != tt.file || info.Push != tt.push || info.Fetch != tt.fetch {
+			t.Errorf("%s %s: got %s %q %q push=%v fetch=%v", tt.method, tt.url, info.Operation, info.Service, info.File, info.Push, info.Fetch)
+		}
+		if info.Repo != "team/app" && info.Operation != OpAdmin {
+			t.Errorf("%s %s: got repo %q", tt.method, tt.url, info.Repo)
+		}
+		if info.Method != tt.method || info.UserAgent != "git/2.39.0" || info.Request != req {
+			t.Errorf("%s %s: request details missing: %+v", tt.method, tt.url, info)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	middleware, err := TrustedProxies("10.0.0.0/8", "192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientIP(r)
+	}))
+
+	tests := []struct {
+		remote string
+		xff    string
+		want   string
+	}{
+		{"203.0.113.7:1234", "", "203.0.113.7"},
+		{"203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"192.168.1.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.1.2.3:1234", "6.6.6.6, 198.51.100.1, 10.0.0.5", "198.51.100.1"},
+		{"10.1.2.3:1234", "10.0.0.9", "10.0.0.9"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/repo/info/refs", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tt.want {
+			t.Errorf("%s via %q: got %s, want %s", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+
+	if _, err := TrustedProxies("not an ip"); err == nil {
+		t.Error("invalid proxies should be rejected")
+	}
+}
@@ -0,0 +1,340 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofunky/githttp"
+)
+
+// Token scopes
+const (
+	// Fetch from repositories
+	ScopeRepoRead = "repo:read"
+	// Fetch from and push to repositories
+	ScopeRepoWrite = "repo:write"
+	// Everything, including the management of tokens
+	ScopeAdmin = "admin"
+)
+
+// SchemeToken is the identity scheme of requests authenticated with a personal access token
+const SchemeToken = "token"
+
+// tokenPrefix marks the secrets of personal access tokens
+const tokenPrefix = "ghpat_"
+
+var (
+	// ErrTokenNotFound is returned for unknown token ids
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrInvalidScope is returned when creating tokens with unknown scopes
+	ErrInvalidScope = errors.New("invalid token scope")
+)
+
+// Token is a personal access token. Its secret is only stored as a hash.
+type Token struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+
+	// Granted scopes, e.g. ScopeRepoRead
+	Scopes []string `json:"scopes"`
+	// Repositories the token is restricted to, all if empty
+	Repos []string `json:"repos,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	// Zero if the token never expires
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Zero if the token was never used
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+// HasScope returns true if the token was granted the scope.
+// The write scope implies the read scope, the admin scope implies all scopes.
+func (t Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if scopeGrants(s, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeGrants returns true if the granted scope implies the required one.
+func scopeGrants(granted string, required string) bool {
+	return granted == required || granted == ScopeAdmin || (granted == ScopeRepoWrite && required == ScopeRepoRead)
+}
+
+func validScope(scope string) bool {
+	return scope == ScopeRepoRead || scope == ScopeRepoWrite || scope == ScopeAdmin
+}
+
+// requiredScope returns the scope that a request requires.
+func requiredScope(info AuthInfo) string {
+	switch {
+	case info.Push:
+		return ScopeRepoWrite
+	case info.Operation == OpAdmin:
+		return ScopeAdmin
+	}
+	return ScopeRepoRead
+}
+
+// Expired returns true if the token is expired at the given time.
+func (t Token) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// allowsRepo returns true if the token is not restricted to other repositories.
+func (t Token) allowsRepo(repo string) bool {
+	if len(t.Repos) == 0 {
+		return true
+	}
+	for _, r := range t.Repos {
+		if r == repo {
+			return true
+		}
+	}
+	return false
+}
+
+// storedToken is a token as persisted on disk.
+type storedToken struct {
+	Token
+	// Hex encoded SHA-256 hash of the secret
+	Hash string `json:"hash"`
+}
+
+// TokenStore persists personal access tokens in a JSON file.
+type TokenStore struct {
+	// Called after a token was revoked, e.g. to invalidate a Cache
+	OnRevoke func(token Token)
+
+	path string
+
+	mu       sync.Mutex
+	tokens   map[string]*storedToken
+	byHash   map[string]*storedToken
+	lastSave time.Time
+	dirty    bool
+	now      func() time.Time
+}
+
+// NewTokenStore opens the token store at path. The file is created on the first write.
+func NewTokenStore(path string) (*TokenStore, error) {
+	s := &TokenStore{
+		path:   path,
+		tokens: map[string]*storedToken{},
+		byHash: map[string]*storedToken{},
+		now:    time.Now,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []*storedToken
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	for _, t := range stored {
+		s.tokens[t.ID] = t
+		s.byHash[t.Hash] = t
+	}
+	return s, nil
+}
+
+// Create creates a token and returns it together with its secret.
+// The secret can't be recovered later on.
+func (s *TokenStore) Create(username string, name string, scopes []string, repos []string, expiresAt time.Time) (Token, string, error) {
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return Token{}, "", ErrInvalidScope
+		}
+	}
+
+	id, err := randomString(9)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret = tokenPrefix + secret
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := &storedToken{
+		Token: Token{
+			ID:        id,
+			Username:  username,
+			Name:      name,
+			Scopes:    scopes,
+			Repos:     repos,
+			CreatedAt: s.now(),
+			ExpiresAt: expiresAt,
+		},
+		Hash: hashSecret(secret),
+	}
+	s.tokens[t.ID] = t
+	s.byHash[t.Hash] = t
+	if err := s.save(); err != nil {
+		delete(s.tokens, t.ID)
+		delete(s.byHash, t.Hash)
+		return Token{}, "", err
+	}
+	return t.Token, secret, nil
+}
+
+// List returns the tokens of a user.
+func (s *TokenStore) List(username string) []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tokens []Token
+	for _, t := range s.tokens {
+		if t.Username == username {
+			tokens = append(tokens, t.Token)
+		}
+	}
+	return tokens
+}
+
+// Get returns a token by its id.
+func (s *TokenStore) Get(id string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return Token{}, ErrTokenNotFound
+	}
+	return t.Token, nil
+}
+
+// Revoke deletes a token of a user.
+func (s *TokenStore) Revoke(username string, id string) error {
+	t, err := s.revoke(username, id)
+	if err != nil {
+		return err
+	}
+	if s.OnRevoke != nil {
+		s.OnRevoke(t)
+	}
+	return nil
+}
+
+func (s *TokenStore) revoke(username string, id string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok || t.Username != username {
+		return Token{}, ErrTokenNotFound
+	}
+	delete(s.tokens, id)
+	delete(s.byHash, t.Hash)
+	return t.Token, s.save()
+}
+
+// Verify is a Verifier that accepts token secrets as Basic password or bearer token.
+// A Basic username must be the one of the token's owner.
+// Pushes require the ScopeRepoWrite scope, OpAdmin requests the ScopeAdmin scope
+// and all other requests ScopeRepoRead.
+func (s *TokenStore) Verify(info AuthInfo) (*githttp.Identity, error) {
+	if !strings.HasPrefix(info.Password, tokenPrefix) {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.byHash[hashSecret(info.Password)]
+	now := s.now()
+	if !ok || t.Expired(now) || !t.allowsRepo(info.Repo) || (info.Username != "" && info.Username != t.Username) {
+		return nil, nil
+	}
+	if !t.HasScope(requiredScope(info)) {
+		return nil, nil
+	}
+
+	// Persist the last usage at most once a minute
+	t.LastUsedAt = now
+	s.dirty = true
+	if now.Sub(s.lastSave) > time.Minute {
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &githttp.Identity{
+		Username: t.Username,
+		Scheme:   SchemeToken,
+		TokenID:  t.ID,
+	}, nil
+}
+
+// Flush writes pending last usage timestamps to disk.
+func (s *TokenStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes all tokens to disk, s.mu must be held.
+func (s *TokenStore) save() error {
+	stored := make([]*storedToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		stored = append(stored, t)
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.lastSave = s.now()
+	s.dirty = false
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofunky/githttp"
+)
+
+// tokenRequest is the body of a token creation request
+type tokenRequest struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	Repos     []string  `json:"repos,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// tokenResponse is a created token together with its secret
+type tokenResponse struct {
+	Token
+	Secret string `json:"secret"`
+}
+
+// Handler returns the token management API of the store.
+// It must be wrapped by an authenticator, users manage their own tokens only.
+// Requests authenticated with a token require the ScopeAdmin scope.
+//
+//	GET    /      lists the tokens of the user
+//	POST   /      creates a token, the response contains its secret
+//	DELETE /{id}  revokes a token
+func (s *TokenStore) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := githttp.RequestIdentity(r)
+		if identity == nil {
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+		if identity.TokenID != "" {
+			t, err := s.Get(identity.TokenID)
+			if err != nil || !t.HasScope(ScopeAdmin) {
+				http.Error(w, "Forbidden", 403)
+				return
+			}
+		}
+
+		id := strings.Trim(r.URL.Path, "/")
+		switch {
+		case r.Method == "GET" && id == "":
+			tokens := s.List(identity.Username)
+			sort.Slice(tokens, func(i, j int) bool {
+				return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+			})
+			if tokens == nil {
+				tokens = []Token{}
+			}
+			writeJSON(w, 200, tokens)
+		case r.Method == "POST" && id == "":
+			var req tokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			t, secret, err := s.Create(identity.Username, req.Name, req.Scopes, req.Repos, req.ExpiresAt)
+			if err == ErrInvalidScope {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			writeJSON(w, 201, tokenResponse{t, secret})
+		case r.Method == "DELETE" && id != "":
+			err := s.Revoke(identity.Username, id)
+			if err == ErrTokenNotFound {
+				http.Error(w, err.Error(), 404)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.WriteHeader(204)
+		default:
+			http.Error(w, "Method Not Allowed", 405)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
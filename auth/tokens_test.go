@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofunky/githttp"
+)
+
+func newTestTokenStore(t *testing.T) (*TokenStore, func()) {
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewTokenStore(filepath.Join(dir, "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func TestTokenStoreVerify(t *testing.T) {
+	store, cleanup := newTestTokenStore(t)
+	defer cleanup()
+
+	read, readSecret, err := store.Create("jane", "ci", []string{ScopeRepoRead}, []string{"team/app"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, writeSecret, err := store.Create("jane", "deploy", []string{ScopeRepoWrite}, nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, expiredSecret, err := store.Create("jane", "old", []string{ScopeRepoWrite}, nil, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Create("jane", "bad", []string{"everything"}, nil, time.Time{}); err != ErrInvalidScope {
+		t.Errorf("got %v, want ErrInvalidScope", err)
+	}
+
+	tests := []struct {
+		name string
+		info AuthInfo
+		want bool
+	}{
+		{"basic password", AuthInfo{Username: "jane", Password: readSecret, Repo: "team/app", Fetch: true}, true},
+		{"other username", AuthInfo{Username: "john", Password: readSecret, Repo: "team/app", Fetch: true}, false},
+		{"bearer", AuthInfo{Password: readSecret, Scheme: SchemeBearer, Repo: "team/app"}, true},
+		{"other repo", AuthInfo{Password: readSecret, Repo: "team/other"}, false},
+		{"missing write scope", AuthInfo{Password: readSecret, Repo: "team/app", Push: true}, false},
+		{"write scope", AuthInfo{Password: writeSecret, Repo: "team/other", Push: true}, true},
+		{"expired", AuthInfo{Password: expiredSecret, Repo: "team/app"}, false},
+		{"unknown", AuthInfo{Password: tokenPrefix + "unknown", Repo: "team/app"}, false},
+		{"password", AuthInfo{Password: "password", Repo: "team/app"}, false},
+	}
+	for _, tt := range tests {
+		identity, err := store.Verify(tt.info)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if (identity != nil) != tt.want {
+			t.Errorf("%s: got %+v, want %v", tt.name, identity, tt.want)
+		}
+		if identity != nil && (identity.Username != "jane" || identity.Scheme != SchemeToken || identity.TokenID == "") {
+			t.Errorf("%s: unexpected identity %+v", tt.name, identity)
+		}
+	}
+
+	// Tokens and their usage survive a restart
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewTokenStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Get(read.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastUsedAt.IsZero() {
+		t.Error("last usage was not persisted")
+	}
+	if id, _ := reopened.Verify(AuthInfo{Password: readSecret, Repo: "team/app"}); id == nil {
+		t.Error("token should still be valid")
+	}
+
+	// Revoked tokens are rejected
+	if err := store.Revoke("john", read.ID); err != ErrTokenNotFound {
+		t.Errorf("revoked token of another user: %v", err)
+	}
+	if err := store.Revoke("jane", read.ID); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := store.Verify(AuthInfo{Password: readSecret, Repo: "team/app"}); id != nil {
+		t.Error("revoked token should be rejected")
+	}
+}
+
+func TestTokenStoreHandler(t *testing.T) {
+	store, cleanup := newTestTokenStore(t)
+	defer cleanup()
+
+	serve := func(method string, path string, body string, identity *githttp.Identity) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if identity != nil {
+			req = req.WithContext(githttp.WithIdentity(req.Context(), identity))
+		}
+		rr := httptest.NewRecorder()
+		store.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	jane := &githttp.Identity{Username: "jane"}
+
+	if rr := serve("GET", "/", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got %d", rr.Code)
+	}
+
+	rr := serve("POST", "/", `{"name": "ci", "scopes": ["repo:read"]}`, jane)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %s", rr.Code, rr.Body.String())
+	}
+	var created tokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Secret, tokenPrefix) || created.Name != "ci" || created.Username != "jane" {
+		t.Errorf("unexpected token %+v", created)
+	}
+
+	// Tokens without the admin scope can't manage tokens
+	viaToken := &githttp.Identity{Username: "jane", TokenID: created.ID}
+	if rr := serve("GET", "/", "", viaToken); rr.Code != http.StatusForbidden {
+		t.Errorf("token without admin scope: got %d", rr.Code)
+	}
+
+	rr = serve("GET", "/", "", jane)
+	var listed []Token
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != created.ID || strings.Contains(rr.Body.String(), "hash") {
+		t.Errorf("unexpected list %s", rr.Body.String())
+	}
+
+	if rr := serve("DELETE", "/"+created.ID, "", &githttp.Identity{Username: "john"}); rr.Code != http.StatusNotFound {
+		t.Errorf("revoke by another user: got %d", rr.Code)
+	}
+	if rr := serve("DELETE", "/"+created.ID, "", jane); rr.Code != http.StatusNoContent {
+		t.Errorf("revoke: got %d", rr.Code)
+	}
+}
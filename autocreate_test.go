@@ -0,0 +1,86 @@
+package githttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAutoCreateOnPush(t *testing.T) {
+	defer os.RemoveAll("./testdata/autocreate")
+
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot:      "./testdata/autocreate/server",
+		AutoCreate:       true,
+		AutoCreateOnPush: true,
+		AutoCreateAuthorizer: func(identity *Identity, repo string) (bool, error) {
+			return identity != nil && identity.Username != "john", nil
+		},
+		AccessResolver: func(repo string, service string, r *http.Request) (bool, error) {
+			identity := RequestIdentity(r)
+			return service == "upload-pack" || (identity != nil && identity.Username != "bob"), nil
+		},
+		ReceivePack: true,
+		UploadPack:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := r.Header.Get("X-User"); user != "" {
+			r = r.WithContext(WithIdentity(r.Context(), &Identity{Username: user}))
+		}
+		git.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	// Fetches of missing repositories don't create them
+	res, err := http.Get(server.URL + "/team/app" + gitRefs + uploadPack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("fetch: got %d, want 404", res.StatusCode)
+	}
+	if _, err := os.Stat("./testdata/autocreate/server/team/app"); !os.IsNotExist(err) {
+		t.Errorf("fetch created the repository: %v", err)
+	}
+
+	// Neither do pushes of users that may not push
+	req, err := http.NewRequest("GET", server.URL+"/team/app"+gitRefs+receivePack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-User", "bob")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if _, err := os.Stat("./testdata/autocreate/server/team/app"); !os.IsNotExist(err) {
+		t.Errorf("push without access created the repository: %v", err)
+	}
+
+	// Only authorized users create repositories by pushing
+	push := func(user string) (string, error) {
+		path := "./testdata/autocreate/" + user
+		if _, err := initRepo(path, false, true); err != nil {
+			return "", err
+		}
+		return runGit(path, "-c", "http.extraHeader=X-User: "+user, "push", server.URL+"/team/app", "master")
+	}
+	if out, err := push("john"); err == nil {
+		t.Errorf("push of unauthorized user succeeded:\n%s", out)
+	}
+	if _, err := os.Stat("./testdata/autocreate/server/team/app"); !os.IsNotExist(err) {
+		t.Errorf("unauthorized push created the repository: %v", err)
+	}
+	if out, err := push("jane"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if out, err := runGit("./testdata/autocreate", "clone", server.URL+"/team/app", "clone"); err != nil {
+		t.Errorf("clone failed: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,347 @@
+package githttp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// bundleContentType is the media type of git bundles
+const bundleContentType = "application/x-git-bundle"
+
+// gitRef is a ref of a repository or a bundle
+type gitRef struct {
+	name string
+	id   string
+}
+
+// getBundle responds with a git bundle of all refs of a repository, or of the refs selected by ref parameters.
+// Refs are selected by their full name or the name of a branch or tag. Hidden refs are left out like in fetches.
+func (g *gitContext) getBundle(hr HandlerReq) error {
+	w, r, dir := hr.w, hr.r, hr.Dir
+	// Bundles would contain the refs of all namespaces
+	if hr.Namespace != "" {
+		renderNotFound(w)
+		return nil
+	}
+	access, err := g.hasAccess(hr, "upload-pack", false)
+	if err != nil {
+		return err
+	}
+	if !access {
+		return &ErrorNoAccess{hr.Dir}
+	}
+
+	upstream, err := g.upstream(hr.Repo)
+	if err != nil {
+		return err
+	}
+	if upstream != "" {
+		g.updateMirror(dir)
+	}
+
+	hidden, err := g.hiddenRefs(hr)
+	if err != nil {
+		return err
+	}
+	refs, err := g.gitRefs(dir, r.URL.Query()["ref"], hidden)
+	if err == ErrRefNotFound {
+		http.Error(w, err.Error(), 404)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.name
+	}
+	cmd := exec.Command(g.options.GitBinPath, "bundle", "create", "-", "--stdin")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(strings.Join(names, "\n") + "\n")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	hdrNocache(w)
+	w.Header().Set("Content-Type", bundleContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(hr.Repo)+".bundle"))
+	io.Copy(w, stdout)
+	mainError := cmd.Wait()
+
+	for _, ref := range refs {
+		g.requestEvent(hr, Event{Type: FETCH, Commit: ref.id, Error: mainError})
+	}
+	return nil
+}
+
+// gitRefs returns the visible refs of a repository that are selected, all of them and HEAD if none are.
+// ErrRefNotFound is returned if a selected ref doesn't exist or there are no refs at all.
+func (g *gitContext) gitRefs(dir string, selected []string, hidden []string) ([]gitRef, error) {
+	all, err := g.listRefs(dir, "refs/")
+	if err != nil {
+		return nil, err
+	}
+	var visible []gitRef
+	for _, ref := range all {
+		if !isHiddenRef(ref.name, hidden) && !strings.HasPrefix(ref.name, keepAroundPrefix) {
+			visible = append(visible, ref)
+		}
+	}
+
+	if len(selected) == 0 {
+		if len(visible) == 0 {
+			return nil, ErrRefNotFound
+		}
+		// HEAD is checked out by clones of the bundle, unless its branch is hidden
+		if head, err := g.gitCommand(dir, "symbolic-ref", "-q", "HEAD"); err == nil {
+			for _, ref := range visible {
+				if ref.name == strings.TrimSpace(string(head)) {
+					return append(visible, gitRef{"HEAD", ref.id}), nil
+				}
+			}
+		}
+		return visible, nil
+	}
+
+	var refs []gitRef
+	seen := map[string]bool{}
+	for _, name := range selected {
+		found := false
+		for _, ref := range visible {
+			if ref.name == name || ref.name == "refs/heads/"+name || ref.name == "refs/tags/"+name {
+				if !seen[ref.name] {
+					refs = append(refs, ref)
+					seen[ref.name] = true
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, ErrRefNotFound
+		}
+	}
+	return refs, nil
+}
+
+// importBundle creates or updates the refs of a repository from an uploaded git bundle.
+// The ref updates are authorized like the commands of a push and are applied atomically,
+// the response lists them like the report of a push.
+func (g *gitContext) importBundle(hr HandlerReq) error {
+	w, r, dir := hr.w, hr.r, hr.Dir
+	// Bundles can't be restricted to a namespace
+	if hr.Namespace != "" {
+		renderNotFound(w)
+		return nil
+	}
+	access, err := g.hasAccess(hr, "receive-pack", false)
+	switch pushErr := err.(type) {
+	case *ErrorRepoState, *ErrorMirror:
+		e := Event{Type: PUSH, Error: err}
+		if stateErr, ok := pushErr.(*ErrorRepoState); ok {
+			e.State = stateErr.State
+		}
+		g.requestEvent(hr, e)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !access {
+		return &ErrorNoAccess{hr.Dir}
+	}
+
+	// Maintenance waits for imports like for pushes
+	g.locks.lockPush(dir)
+	defer g.locks.unlockPush(dir)
+
+	reader, err := requestReader(r)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// Bundles count against the push quota
+	quota, err := g.pushQuota(dir)
+	if err != nil {
+		return err
+	}
+	var body io.Reader = reader
+	if quota != nil {
+		body = io.LimitReader(reader, quota.size+1)
+	}
+
+	file, err := ioutil.TempFile("", "githttp-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	size, err := io.Copy(file, body)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if quota != nil && size > quota.size {
+		g.requestEvent(hr, Event{Type: PUSH, Error: quota.err})
+		http.Error(w, quota.err.Error(), http.StatusRequestEntityTooLarge)
+		return nil
+	}
+
+	heads, err := g.gitCommand(dir, "bundle", "list-heads", file.Name())
+	if err != nil {
+		http.Error(w, commandError(err), 400)
+		return nil
+	}
+	defer g.lockRefUpdates(dir)()
+	current, err := g.listRefs(dir, "refs/")
+	if err != nil {
+		return err
+	}
+	old := map[string]string{}
+	for _, ref := range current {
+		old[ref.name] = ref.id
+	}
+
+	// Hidden refs can't be updated, like in pushes
+	hidden, err := g.hiddenRefs(hr)
+	if err != nil {
+		return err
+	}
+
+	identity := RequestIdentity(r)
+	var allowed, denied []RefUpdate
+	// Reason of each denied update for the report
+	reasons := map[string]string{}
+	// Pseudo-refs like FETCH_HEAD, which receive-pack refuses as well
+	var invalid []string
+	var head string
+	for _, line := range strings.Split(string(heads), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if fields[1] == "HEAD" {
+			head = fields[0]
+			continue
+		}
+		if !strings.HasPrefix(fields[1], "refs/") {
+			invalid = append(invalid, fields[1])
+			continue
+		}
+		if strings.HasPrefix(fields[1], keepAroundPrefix) {
+			continue
+		}
+		update := RefUpdate{Repo: hr.Repo, Ref: fields[1], Old: ZeroID, New: fields[0]}
+		if id, ok := old[update.Ref]; ok {
+			update.Old = id
+		}
+		// Checked first, unchanged values would reveal hidden refs
+		if isHiddenRef(update.Ref, hidden) {
+			denied = append(denied, update)
+			reasons[update.Ref] = "deny updating a hidden ref"
+			continue
+		}
+		if update.Old == update.New {
+			continue
+		}
+		ok := true
+		if g.options.RefAuthorizer != nil {
+			if ok, err = g.options.RefAuthorizer(identity, update); err != nil {
+				return err
+			}
+		}
+		if ok {
+			allowed = append(allowed, update)
+		} else {
+			denied = append(denied, update)
+			reasons[update.Ref] = "denied"
+		}
+	}
+
+	if len(allowed) > 0 {
+		if _, err := g.gitCommand(dir, "bundle", "unbundle", file.Name()); err != nil {
+			http.Error(w, commandError(err), 400)
+			return nil
+		}
+		var commands strings.Builder
+		for _, u := range allowed {
+			fmt.Fprintf(&commands, "update %s %s %s\n", u.Ref, u.New, u.Old)
+		}
+		cmd := exec.Command(g.options.GitBinPath, "update-ref", "--stdin")
+		cmd.Dir = dir
+		cmd.Stdin = strings.NewReader(commands.String())
+		if _, err := cmd.Output(); err != nil {
+			http.Error(w, commandError(err), 400)
+			return nil
+		}
+		if err := g.journalRequest(hr, current); err != nil {
+			g.requestEvent(hr, Event{Type: PUSH, Error: err})
+		}
+		g.bundleHead(dir, head, allowed)
+		g.schedulePushMirrors(dir)
+	}
+
+	// Fire events and report the ref updates
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, u := range allowed {
+		for _, e := range scanPush(u.Old + " " + u.New + " " + u.Ref) {
+			g.requestEvent(hr, e)
+		}
+		fmt.Fprintf(w, "ok %s\n", u.Ref)
+	}
+	for _, u := range denied {
+		for _, e := range scanPush(u.Old + " " + u.New + " " + u.Ref) {
+			e.Error = &ErrorRefDenied{u.Ref}
+			g.requestEvent(hr, e)
+		}
+		fmt.Fprintf(w, "ng %s %s\n", u.Ref, reasons[u.Ref])
+	}
+	for _, ref := range invalid {
+		fmt.Fprintf(w, "ng %s invalid ref\n", ref)
+	}
+	return nil
+}
+
+// bundleHead points HEAD of a repository without commits to the imported branch that HEAD of the bundle points to.
+func (g *gitContext) bundleHead(dir string, head string, imported []RefUpdate) {
+	if head == "" {
+		return
+	}
+	if _, err := g.gitCommand(dir, "rev-parse", "-q", "--verify", "HEAD"); err == nil {
+		return
+	}
+	for _, u := range imported {
+		if u.New == head && strings.HasPrefix(u.Ref, "refs/heads/") {
+			g.gitCommand(dir, "symbolic-ref", "HEAD", u.Ref)
+			return
+		}
+	}
+}
+
+// listRefs returns the refs of a repository below a prefix in lexical order.
+func (g *gitContext) listRefs(dir string, prefix string) ([]gitRef, error) {
+	out, err := g.gitCommand(dir, "for-each-ref", "--format=%(objectname) %(refname)", prefix)
+	if err != nil {
+		return nil, err
+	}
+	var refs []gitRef
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			refs = append(refs, gitRef{name: fields[1], id: fields[0]})
+		}
+	}
+	return refs, nil
+}
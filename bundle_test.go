@@ -0,0 +1,243 @@
+package githttp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	defer os.RemoveAll("./testdata/bundle")
+
+	var denied []Event
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/bundle/server",
+		AutoCreate:  true,
+		UploadPack:  true,
+		ReceivePack: true,
+		HiddenRefs: func(identity *Identity, repo string) ([]string, error) {
+			return []string{"refs/heads/secret"}, nil
+		},
+		RefAuthorizer: func(identity *Identity, update RefUpdate) (bool, error) {
+			return update.Ref != "refs/heads/protected", nil
+		},
+		EventHandler: func(ev Event) {
+			if _, ok := ev.Error.(*ErrorRefDenied); ok {
+				denied = append(denied, ev)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	client := "./testdata/bundle/client"
+	if out, err := pushTestRepo(client, server.URL+"/src", "master", "master:dev"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if out, err := runGit("./testdata/bundle/server/src", "branch", "secret", "master"); err != nil {
+		t.Fatalf("branch failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(client, "tag", "v1"); err != nil {
+		t.Fatalf("tag failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(client, "push", server.URL+"/src", "v1"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+
+	// Export of all visible refs, which can be cloned
+	downloads := 0
+	download := func(query string) (int, string) {
+		res, err := http.Get(server.URL + "/src/bundle" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != 200 {
+			return res.StatusCode, ""
+		}
+		if res.Header.Get("Content-Type") != bundleContentType {
+			t.Errorf("unexpected content type %q", res.Header.Get("Content-Type"))
+		}
+		downloads++
+		file := filepath.Join("./testdata/bundle", fmt.Sprintf("src%d.bundle", downloads))
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		file, err = filepath.Abs(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, file
+	}
+	listHeads := func(file string) string {
+		out, err := runGit(".", "bundle", "list-heads", file)
+		if err != nil {
+			t.Fatalf("list-heads failed: %v\n%s", err, out)
+		}
+		var refs []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			refs = append(refs, strings.Fields(line)[1])
+		}
+		return strings.Join(refs, " ")
+	}
+
+	code, all := download("")
+	if code != 200 {
+		t.Fatalf("export failed with %d", code)
+	}
+	if refs := listHeads(all); refs != "refs/heads/dev refs/heads/master refs/tags/v1 HEAD" {
+		t.Errorf("unexpected refs %q", refs)
+	}
+	if out, err := runGit("./testdata/bundle", "clone", all, "cloned"); err != nil {
+		t.Fatalf("clone failed: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join("./testdata/bundle/cloned", testFile)); err != nil {
+		t.Errorf("clone has no checkout: %v", err)
+	}
+
+	// Export of selected refs
+	code, selected := download("?ref=dev&ref=refs/tags/v1")
+	if code != 200 {
+		t.Fatalf("export failed with %d", code)
+	}
+	if refs := listHeads(selected); refs != "refs/heads/dev refs/tags/v1" {
+		t.Errorf("unexpected refs %q", refs)
+	}
+	for _, query := range []string{"?ref=secret", "?ref=missing"} {
+		if code, _ := download(query); code != 404 {
+			t.Errorf("%s: got %d, want 404", query, code)
+		}
+	}
+
+	upload := func(repo string, body []byte) (int, string) {
+		res, err := http.Post(server.URL+"/"+repo+"/bundle", bundleContentType, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(data)
+	}
+
+	// Import into a new repository
+	data, err := ioutil.ReadFile(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, report := upload("dst", data)
+	if code != 200 || report != "ok refs/heads/dev\nok refs/heads/master\nok refs/tags/v1\n" {
+		t.Fatalf("import returned %d %q", code, report)
+	}
+	dst := "./testdata/bundle/server/dst"
+	if out, _ := runGit(dst, "symbolic-ref", "HEAD"); out != "refs/heads/master\n" {
+		t.Errorf("unexpected HEAD %q", out)
+	}
+
+	// Incremental import of an update, denied refs are left out
+	if err := ioutil.WriteFile(filepath.Join(client, testFile), []byte("update"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(client, "-c", "user.name=test", "-c", "user.email=test@example.org", "commit", "-am", "update"); err != nil {
+		t.Fatalf("commit failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(client, "branch", "protected"); err != nil {
+		t.Fatalf("branch failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(client, "bundle", "create", "../update.bundle", "v1..master", "protected"); err != nil {
+		t.Fatalf("bundle failed: %v\n%s", err, out)
+	}
+	data, err = ioutil.ReadFile("./testdata/bundle/update.bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, report = upload("dst", data)
+	if code != 200 || report != "ok refs/heads/master\nng refs/heads/protected denied\n" {
+		t.Fatalf("import returned %d %q", code, report)
+	}
+	master, _ := runGit(client, "rev-parse", "master")
+	if out, _ := runGit(dst, "rev-parse", "master"); out != master {
+		t.Errorf("master is %q, want %q", out, master)
+	}
+	if out, err := runGit(dst, "rev-parse", "--verify", "-q", "protected"); err == nil {
+		t.Errorf("denied ref was imported: %s", out)
+	}
+	if len(denied) != 1 || denied[0].Branch != "protected" || denied[0].Repo != "dst" {
+		t.Errorf("unexpected denied events %+v", denied)
+	}
+
+	// Hidden refs can neither be overwritten nor created
+	if out, err := runGit(client, "branch", "secret"); err != nil {
+		t.Fatalf("branch failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(client, "bundle", "create", "../hidden.bundle", "master", "secret"); err != nil {
+		t.Fatalf("bundle failed: %v\n%s", err, out)
+	}
+	hiddenData, err := ioutil.ReadFile("./testdata/bundle/hidden.bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := runGit("./testdata/bundle/server/src", "rev-parse", "secret")
+	for _, repo := range []string{"src", "dst"} {
+		code, report := upload(repo, hiddenData)
+		if code != 200 || !strings.HasSuffix(report, "ng refs/heads/secret deny updating a hidden ref\n") {
+			t.Errorf("%s: import returned %d %q", repo, code, report)
+		}
+	}
+	if out, _ := runGit("./testdata/bundle/server/src", "rev-parse", "secret"); out != secret {
+		t.Errorf("hidden ref was overwritten: %q, want %q", out, secret)
+	}
+	if out, err := runGit(dst, "rev-parse", "--verify", "-q", "secret"); err == nil {
+		t.Errorf("hidden ref was created: %s", out)
+	}
+	if len(denied) != 3 || denied[1].Branch != "secret" || denied[2].Branch != "secret" {
+		t.Errorf("unexpected denied events %+v", denied)
+	}
+
+	// Pseudo-refs aren't refs
+	if err := ioutil.WriteFile(filepath.Join(client, ".git", "CUSTOM_HEAD"), []byte(master), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(client, "bundle", "create", "../pseudo.bundle", "master", "CUSTOM_HEAD"); err != nil {
+		t.Fatalf("bundle failed: %v\n%s", err, out)
+	}
+	pseudoData, err := ioutil.ReadFile("./testdata/bundle/pseudo.bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, report := upload("dst", pseudoData); code != 200 || report != "ng CUSTOM_HEAD invalid ref\n" {
+		t.Errorf("import returned %d %q", code, report)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir(dst), "CUSTOM_HEAD")); !os.IsNotExist(err) {
+		t.Errorf("pseudo-ref was imported: %v", err)
+	}
+
+	// Prerequisites must exist
+	if code, report := upload("other", data); code != 400 {
+		t.Errorf("import without prerequisites returned %d %q", code, report)
+	}
+	if code, report := upload("dst", []byte("garbage")); code != 400 {
+		t.Errorf("import of garbage returned %d %q", code, report)
+	}
+	if err := git.SetRepoState("dst", StateReadOnly, nil); err != nil {
+		t.Fatal(err)
+	}
+	if code, report := upload("dst", data); code != 403 {
+		t.Errorf("import into read-only repository returned %d %q", code, report)
+	}
+}
@@ -3,6 +3,8 @@ package githttp
 import (
 	"errors"
 	"fmt"
+	"os/exec"
+	"strings"
 )
 
 // ErrMissingArgument is to be returned if there are git server options missing that are passed to the factory.
@@ -17,3 +19,140 @@ type ErrorNoAccess struct {
 func (e *ErrorNoAccess) Error() string {
 	return fmt.Sprintf("could not access repo at '%s'", e.Dir)
 }
+
+// ErrorRefDenied is the error of events for ref updates that were denied by the RefAuthorizer
+type ErrorRefDenied struct {
+	// Full name of the denied ref
+	Ref string
+}
+
+func (e *ErrorRefDenied) Error() string {
+	return fmt.Sprintf("update of '%s' was denied", e.Ref)
+}
+
+// ErrorRepoState is returned for pushes to repositories whose state doesn't allow them
+type ErrorRepoState struct {
+	// Path to directory of repo accessed
+	Dir   string
+	State RepoState
+}
+
+func (e *ErrorRepoState) Error() string {
+	return fmt.Sprintf("the repository is %s, pushes are not allowed", e.State)
+}
+
+// ErrorHiddenRef is returned if a client wants the tip of a hidden ref
+type ErrorHiddenRef struct {
+	// Object id the client wanted
+	ID string
+}
+
+func (e *ErrorHiddenRef) Error() string {
+	return fmt.Sprintf("upload-pack: not our ref %s", e.ID)
+}
+
+// ErrorQuotaExceeded is returned for pushes that exceed the push size limit or the repository size limit
+type ErrorQuotaExceeded struct {
+	// Exceeded limit, push size or repository size
+	Limit string
+	// Limit in bytes
+	Max int64
+}
+
+func (e *ErrorQuotaExceeded) Error() string {
+	return fmt.Sprintf("%s limit of %s exceeded", e.Limit, formatSize(e.Max))
+}
+
+// ErrorMaintenance is returned if a maintenance task of a repository failed
+type ErrorMaintenance struct {
+	// Path to directory of repo maintained
+	Dir string
+	// Failed task, e.g. repack
+	Task string
+	Err  error
+}
+
+func (e *ErrorMaintenance) Error() string {
+	return fmt.Sprintf("maintenance task %s of '%s' failed: %s", e.Task, e.Dir, commandError(e.Err))
+}
+
+// ErrorMirror is returned for pushes to mirror repositories
+type ErrorMirror struct {
+	// Path to directory of repo accessed
+	Dir string
+}
+
+func (e *ErrorMirror) Error() string {
+	return "the repository is a mirror, pushes are not allowed"
+}
+
+// ErrorMirrorFetch is the error of MIRROR events for fetches from upstream that failed
+type ErrorMirrorFetch struct {
+	// Path to directory of the mirror
+	Dir string
+	Err error
+}
+
+func (e *ErrorMirrorFetch) Error() string {
+	return fmt.Sprintf("fetch of mirror '%s' failed: %s", e.Dir, commandError(e.Err))
+}
+
+// ErrorPushMirror is the error of PUSH_MIRROR events for replications that failed
+type ErrorPushMirror struct {
+	// Path to directory of the replicated repo
+	Dir string
+	// Name of the push mirror
+	Remote string
+	Err    error
+}
+
+func (e *ErrorPushMirror) Error() string {
+	return fmt.Sprintf("push of '%s' to mirror %s failed: %s", e.Dir, e.Remote, commandError(e.Err))
+}
+
+// commandError returns the error output of a failed git command, or the error itself.
+func commandError(err error) string {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return strings.TrimSpace(string(exitErr.Stderr))
+	}
+	return err.Error()
+}
+
+var (
+	// ErrRepoBusy is returned when maintaining a repository that is being pushed to
+	ErrRepoBusy = errors.New("repository is busy")
+	// ErrMirrorExists is returned when adding a push mirror with the name of an existing remote
+	ErrMirrorExists = errors.New("remote already exists")
+	// ErrMirrorNotFound is returned for push mirrors that don't exist
+	ErrMirrorNotFound = errors.New("push mirror not found")
+	// ErrInvalidMirrorName is returned for malformed push mirror names
+	ErrInvalidMirrorName = errors.New("invalid push mirror name")
+	// ErrInvalidNamespace is returned if the Namespace hook maps a repository to a malformed git namespace
+	ErrInvalidNamespace = errors.New("invalid namespace")
+	// ErrRedirectLoop is returned for redirects that would lead back to their origin
+	ErrRedirectLoop = errors.New("redirect loop")
+	// ErrRedirectNotFound is returned when removing a redirect that doesn't exist
+	ErrRedirectNotFound = errors.New("redirect not found")
+	// ErrTrashNotFound is returned for ids of repositories that aren't in the trash
+	ErrTrashNotFound = errors.New("repository not found in trash")
+	// ErrRefNotFound is returned for refs that don't exist
+	ErrRefNotFound = errors.New("ref not found")
+	// ErrRefValueNotFound is returned for ref values that aren't recorded in the history of the ref
+	ErrRefValueNotFound = errors.New("value not found in the history of the ref")
+	// ErrSizeLimit is returned by the RpcReader when reading beyond its MaxSize
+	ErrSizeLimit = errors.New("size limit exceeded")
+	// ErrInvalidRepoPath is returned for repository paths outside of the project root
+	ErrInvalidRepoPath = errors.New("invalid repository path")
+	// ErrRepoExists is returned when creating or moving to a repository that already exists
+	ErrRepoExists = errors.New("repository already exists")
+	// ErrRepoHasForks is returned when deleting a repository whose objects are shared with forks
+	ErrRepoHasForks = errors.New("repository has forks")
+	// ErrInvalidBranch is returned for invalid default branch names
+	ErrInvalidBranch = errors.New("invalid branch name")
+	// ErrInvalidState is returned for unknown repository states
+	ErrInvalidState = errors.New("invalid repository state")
+	// ErrInvalidConfigKey is returned for malformed git config keys
+	ErrInvalidConfigKey = errors.New("invalid config key")
+	// ErrConfigNotFound is returned for git config keys that aren't set
+	ErrConfigNotFound = errors.New("config key not found")
+)
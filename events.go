@@ -7,7 +7,7 @@ import (
 
 // An event (triggered on push/pull)
 type Event struct {
-	// One of tag/push/fetch
+	// One of tag/push/fetch/repo-state/maintenance/mirror/push-mirror/repo-delete/repo-restore/repo-purge
 	Type EventType `json:"type"`
 
 	// //
@@ -20,6 +20,12 @@ type Event struct {
 	// Path to bare repo
 	Dir string
 
+	// Requested repository path, the logical name of namespaced repositories
+	Repo string `json:"repo,omitempty"`
+
+	// Git namespace of the repository within Dir, if any
+	Namespace string `json:"namespace,omitempty"`
+
 	// //
 	// Set for pushes or tagging
 	// //
@@ -31,6 +37,15 @@ type Event struct {
 	// during this action/event
 	Error error
 
+	// State of the repository, set for state changes and rejected pushes
+	State RepoState `json:"state,omitempty"`
+
+	// Name of the push mirror, set for push mirror events
+	Remote string `json:"remote,omitempty"`
+
+	// Authenticated user that triggered the event (nil if anonymous)
+	Identity *Identity `json:"identity,omitempty"`
+
 	// Http stuff
 	Request *http.Request
 }
@@ -43,6 +58,13 @@ const (
 	PUSH
 	FETCH
 	PUSH_FORCE
+	REPO_STATE
+	MAINTENANCE
+	MIRROR
+	PUSH_MIRROR
+	REPO_DELETE
+	REPO_RESTORE
+	REPO_PURGE
 )
 
 func (e EventType) String() string {
@@ -55,6 +77,20 @@ func (e EventType) String() string {
 		return "push-force"
 	case FETCH:
 		return "fetch"
+	case REPO_STATE:
+		return "repo-state"
+	case MAINTENANCE:
+		return "maintenance"
+	case MIRROR:
+		return "mirror"
+	case PUSH_MIRROR:
+		return "push-mirror"
+	case REPO_DELETE:
+		return "repo-delete"
+	case REPO_RESTORE:
+		return "repo-restore"
+	case REPO_PURGE:
+		return "repo-purge"
 	}
 	return "unknown"
 }
@@ -74,6 +110,20 @@ func (e EventType) UnmarshalJSON(data []byte) error {
 		e = PUSH_FORCE
 	case "fetch":
 		e = FETCH
+	case "repo-state":
+		e = REPO_STATE
+	case "maintenance":
+		e = MAINTENANCE
+	case "mirror":
+		e = MIRROR
+	case "push-mirror":
+		e = PUSH_MIRROR
+	case "repo-delete":
+		e = REPO_DELETE
+	case "repo-restore":
+		e = REPO_RESTORE
+	case "repo-purge":
+		e = REPO_PURGE
 	default:
 		return fmt.Errorf("'%s' is not a known git event type", str)
 	}
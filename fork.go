@@ -0,0 +1,235 @@
+package githttp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/config"
+)
+
+// configSection is the git config section of githttp, e.g. with the links between forks and parents
+const configSection = "githttp"
+
+// ForkRepo creates a fork of a repository and returns its absolute local directory.
+// The fork shares the objects of its parent via objects/info/alternates and starts with its branches and tags.
+// The parent keeps unreachable objects and can't be deleted while it has forks.
+func (g *gitContext) ForkRepo(parent string, fork string) (string, error) {
+	parentDir, err := g.managedDir(parent)
+	if err != nil {
+		return "", err
+	}
+	forkDir, err := g.managedDir(fork)
+	if err != nil {
+		return "", err
+	}
+	if !isRepoDir(parentDir) {
+		return "", gogit.ErrRepositoryNotExists
+	}
+	if _, err := os.Stat(forkDir); err == nil {
+		return "", ErrRepoExists
+	}
+
+	if err := g.fork(parentDir, forkDir); err != nil {
+		os.RemoveAll(forkDir)
+		g.removeEmptyParents(forkDir)
+		return "", err
+	}
+	return forkDir, g.dropRedirect(fork)
+}
+
+func (g *gitContext) fork(parentDir string, forkDir string) error {
+	parentRepo, err := gogit.PlainOpen(parentDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(forkDir, os.ModePerm); err != nil {
+		return err
+	}
+	forkRepo, err := gogit.PlainInit(forkDir, true)
+	if err != nil {
+		return err
+	}
+	if err := writeAlternates(parentDir, forkDir); err != nil {
+		return err
+	}
+
+	// The objects of the refs are available via the alternates
+	refs, err := parentRepo.References()
+	if err != nil {
+		return err
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !(ref.Name().IsBranch() || ref.Name().IsTag()) {
+			return nil
+		}
+		return forkRepo.Storer.SetReference(ref)
+	})
+	if err != nil {
+		return err
+	}
+	head, err := parentRepo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		if err := forkRepo.Storer.SetReference(head); err != nil {
+			return err
+		}
+	}
+
+	parentLink, err := g.link(parentDir)
+	if err != nil {
+		return err
+	}
+	forkLink, err := g.link(forkDir)
+	if err != nil {
+		return err
+	}
+	err = updateLinks(forkDir, func(cfg *config.Config) {
+		cfg.Section(configSection).SetOption("parent", parentLink)
+	})
+	if err != nil {
+		return err
+	}
+	return updateLinks(parentDir, func(cfg *config.Config) {
+		cfg.Section(configSection).AddOption("fork", forkLink)
+		// Objects that become unreachable in the parent may still be used by forks
+		if cfg.Section("gc").Option("pruneExpire") == "" {
+			cfg.Section("gc").SetOption("pruneExpire", "never")
+			cfg.Section(configSection).SetOption("prunePinned", "true")
+		}
+	})
+}
+
+// unlinkFork removes a deleted fork from its parent.
+func (g *gitContext) unlinkFork(parentLink string, forkLink string) error {
+	root, err := g.root()
+	if err != nil {
+		return err
+	}
+	return updateLinks(filepath.Join(root, parentLink), func(cfg *config.Config) {
+		section := cfg.Section(configSection)
+		section.Options = withoutValue(section.Options, "fork", forkLink)
+		if len(section.Options.GetAll("fork")) == 0 && section.Option("prunePinned") == "true" {
+			section.RemoveOption("prunePinned")
+			cfg.Section("gc").RemoveOption("pruneExpire")
+		}
+	})
+}
+
+// relinkMoved updates the alternates and links of forks after a repository was moved.
+func (g *gitContext) relinkMoved(fromDir string, toDir string) error {
+	root, err := g.root()
+	if err != nil {
+		return err
+	}
+	fromLink, err := g.link(fromDir)
+	if err != nil {
+		return err
+	}
+	toLink, err := g.link(toDir)
+	if err != nil {
+		return err
+	}
+	parent, forks, err := readLinks(toDir)
+	if err != nil {
+		return err
+	}
+
+	if parent != "" {
+		parentDir := filepath.Join(root, parent)
+		if err := writeAlternates(parentDir, toDir); err != nil {
+			return err
+		}
+		err := updateLinks(parentDir, func(cfg *config.Config) {
+			section := cfg.Section(configSection)
+			section.Options = withoutValue(section.Options, "fork", fromLink)
+			section.AddOption("fork", toLink)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, fork := range forks {
+		forkDir := filepath.Join(root, fork)
+		if err := writeAlternates(toDir, forkDir); err != nil {
+			return err
+		}
+		err := updateLinks(forkDir, func(cfg *config.Config) {
+			cfg.Section(configSection).SetOption("parent", toLink)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// link returns the path of a repository directory relative to the project root.
+func (g *gitContext) link(dir string) (string, error) {
+	root, err := g.root()
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// writeAlternates points the object storage of a fork to its parent's, relative to the fork's,
+// so that the project root can be moved as a whole.
+func writeAlternates(parentDir string, forkDir string) error {
+	forkObjects := filepath.Join(gitDir(forkDir), "objects")
+	rel, err := filepath.Rel(forkObjects, filepath.Join(gitDir(parentDir), "objects"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(forkObjects, "info"), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(forkObjects, "info", "alternates"), []byte(filepath.ToSlash(rel)+"\n"), 0644)
+}
+
+// readLinks returns the parent and the forks of a repository.
+func readLinks(dir string) (string, []string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return "", nil, err
+	}
+	section := cfg.Raw.Section(configSection)
+	return section.Option("parent"), section.Options.GetAll("fork"), nil
+}
+
+// updateLinks modifies the git config of a repository.
+func updateLinks(dir string, modify func(cfg *config.Config)) error {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	modify(cfg.Raw)
+	return repo.Storer.SetConfig(cfg)
+}
+
+func withoutValue(options config.Options, key string, value string) config.Options {
+	var kept config.Options
+	for _, o := range options {
+		if o.IsKey(key) && o.Value == value {
+			continue
+		}
+		kept = append(kept, o)
+	}
+	return kept
+}
@@ -0,0 +1,99 @@
+package githttp
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForkRepo(t *testing.T) {
+	defer os.RemoveAll("./testdata/fork")
+
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/fork/server",
+		AutoCreate:  true,
+		ReceivePack: true,
+		UploadPack:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	if out, err := pushTestRepo("./testdata/fork/client", server.URL+"/team/app", "master", "master:feature", "--tags"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if _, err := git.ForkRepo("team/app", "jane/app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git.ForkRepo("team/app", "jane/app"); err != ErrRepoExists {
+		t.Errorf("got %v, want ErrRepoExists", err)
+	}
+
+	// The fork shares the objects and starts with the refs of the parent
+	forkDir := "./testdata/fork/server/jane/app"
+	if out, err := runGit(forkDir, "cat-file", "-p", "master"); err != nil || !strings.Contains(out, "tree ") {
+		t.Errorf("fork can't read the parent's objects: %v\n%s", err, out)
+	}
+	if out, _ := runGit(forkDir, "count-objects", "-v"); !strings.Contains(out, "count: 0\n") || !strings.Contains(out, "in-pack: 0\n") {
+		t.Errorf("fork has objects of its own:\n%s", out)
+	}
+	if out, _ := runGit(forkDir, "for-each-ref", "--format=%(refname)"); out != "refs/heads/feature\nrefs/heads/master\n" {
+		t.Errorf("unexpected refs %q", out)
+	}
+	if out, err := runGit("./testdata/fork", "clone", server.URL+"/jane/app", "clone"); err != nil {
+		t.Fatalf("clone of fork failed: %v\n%s", err, out)
+	}
+
+	// The parent keeps unreachable objects and can't be deleted
+	if out, _ := runGit("./testdata/fork/server/team/app", "config", "gc.pruneExpire"); out != "never\n" {
+		t.Errorf("pruning isn't disabled: %q", out)
+	}
+	if err := git.DeleteRepo("team/app"); err != ErrRepoHasForks {
+		t.Errorf("got %v, want ErrRepoHasForks", err)
+	}
+	info, err := git.RepoInfo("team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Forks) != 1 || info.Forks[0] != "jane/app" {
+		t.Errorf("unexpected forks %v", info.Forks)
+	}
+
+	// Moving the parent keeps the fork intact
+	if err := git.MoveRepo("team/app", "org/team/app"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(forkDir, "fsck", "--connectivity-only"); err != nil {
+		t.Errorf("fork is broken after move: %v\n%s", err, out)
+	}
+	info, err = git.RepoInfo("jane/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Parent != "org/team/app" {
+		t.Errorf("unexpected parent %q", info.Parent)
+	}
+
+	// Moving the fork as well
+	if err := git.MoveRepo("jane/app", "john/forks/app"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(filepath.Join("./testdata/fork/server/john/forks/app"), "fsck", "--connectivity-only"); err != nil {
+		t.Errorf("fork is broken after move: %v\n%s", err, out)
+	}
+
+	// Without forks, the parent can be pruned and deleted again
+	if err := git.DeleteRepo("john/forks/app"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit("./testdata/fork/server/org/team/app", "config", "gc.pruneExpire"); err == nil {
+		t.Errorf("pruning is still disabled: %q", out)
+	}
+	if err := git.DeleteRepo("org/team/app"); err != nil {
+		t.Error(err)
+	}
+}
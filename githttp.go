@@ -1,6 +1,8 @@
 package githttp
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	gogit "gopkg.in/src-d/go-git.v4"
 	"io"
@@ -10,6 +12,8 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 type (
@@ -17,11 +21,60 @@ type (
 	GitHTTP interface {
 		Init() (*gitContext, error)
 		ServeHTTP(w http.ResponseWriter, r *http.Request)
+		RepoDir(repoPath string) (string, error)
+
+		// Repository management
+		CreateRepo(repoPath string, options CreateOptions) (string, error)
+		DeleteRepo(repoPath string) error
+		MoveRepo(from string, to string) error
+		ForkRepo(parent string, fork string) (string, error)
+		ListRepos() ([]string, error)
+		RepoInfo(repoPath string) (RepoInfo, error)
+		RepoConfig(repoPath string, key string) (string, error)
+		SetRepoConfig(repoPath string, key string, value string) error
+		UnsetRepoConfig(repoPath string, key string) error
+		RepoState(repoPath string) (RepoState, error)
+		SetRepoState(repoPath string, state RepoState, identity *Identity) error
+
+		// Repository maintenance
+		RunMaintenance(ctx context.Context) error
+		MaintainRepo(repoPath string) error
+
+		// Push mirrors
+		AddPushMirror(repoPath string, name string, url string) error
+		RemovePushMirror(repoPath string, name string) error
+		PushMirrors(repoPath string) ([]PushMirror, error)
+
+		// Redirects of renamed repositories
+		Redirects() (map[string]string, error)
+		SetRedirect(from string, to string) error
+		RemoveRedirect(from string) error
+
+		// Trash of deleted repositories
+		TrashedRepos() ([]TrashedRepo, error)
+		RestoreRepo(id string) (string, error)
+		PurgeRepo(id string) error
+		PurgeTrash() error
+		RunTrashPurge(ctx context.Context) error
+
+		// Journal of ref updates
+		RefHistory(repoPath string, ref string) ([]RefChange, error)
+		RestoreRef(repoPath string, ref string, id string, identity *Identity, requestID string) error
 	}
 
 	// gitContext is the context on that the git server operates on.
 	gitContext struct {
 		options GitOptions
+		// Keeps maintenance and pushes apart
+		locks repoLocks
+		// Mutex per mirror directory, held while cloning or fetching from upstream
+		mirrorFetches sync.Map
+		// Replications to push mirrors
+		pushMirrors pushMirrorQueue
+		// Old paths of renamed repositories
+		redirects redirectTable
+		// Mutex per repository directory, held while a request updates refs and records them in the journal
+		refUpdates sync.Map
 	}
 
 	// GitOptions contains the the git server options.
@@ -32,21 +85,83 @@ type (
 		// Path to git binary
 		GitBinPath string
 
-		// Access rules
+		// Access rules, unless the repository's http.uploadpack and http.receivepack settings
+		// or the AccessResolver decide
 		UploadPack  bool
 		ReceivePack bool
 
+		// Decides if a service (upload-pack or receive-pack) is enabled for a repository,
+		// unless its git config does
+		AccessResolver func(repo string, service string, r *http.Request) (bool, error)
+
 		// To disable bare init
 		NoBare bool
 
 		// Implicit generation
 		AutoCreate bool
 
+		// Restricts AutoCreate to pushes, fetches of missing repositories are answered with 404.
+		// Pushes must be enabled by ReceivePack or the AccessResolver.
+		AutoCreateOnPush bool
+
+		// Decides if a user may create a repository by AutoCreate, everyone may if nil
+		AutoCreateAuthorizer func(identity *Identity, repo string) (bool, error)
+
+		// Initializes new repositories, they are empty if nil
+		Template *Template
+
+		// Branch that HEAD of new repositories points to, master if empty
+		DefaultBranch string
+
 		// May be used to create a common context for the preprocessing funcs
 		Prep func() Preprocesser
 
 		// Event handling functions
 		EventHandler func(ev Event)
+
+		// Authorizes the ref updates of pushes one by one,
+		// denied updates are reported back to the client and not applied
+		RefAuthorizer func(identity *Identity, update RefUpdate) (bool, error)
+
+		// Maps a requested repository to the physical repository that stores it and a git namespace within,
+		// e.g. /customers/acme to /customers and acme. Repositories aren't namespaced if nil or empty.
+		Namespace func(repo string) (string, string, error)
+
+		// Returns the ref prefixes to hide from a user of a repository (e.g. refs/heads/security),
+		// hidden refs are neither advertised nor fetchable by their tips
+		HiddenRefs func(identity *Identity, repo string) ([]string, error)
+
+		// Maximum size of a push request in bytes, unlimited if zero,
+		// overridden per repository by githttp.maxPushSize
+		MaxPushSize int64
+
+		// Maximum size of a repository on disk in bytes, unlimited if zero,
+		// overridden per repository by githttp.maxRepoSize
+		MaxRepoSize int64
+
+		// Configures RunMaintenance
+		Maintenance MaintenanceOptions
+
+		// Returns the upstream URL of a mirror repository, empty if the repository isn't a mirror.
+		// Mirrors are cloned on first access, fetched from upstream before their refs are advertised
+		// and reject pushes.
+		Mirror func(repo string) (string, error)
+
+		// Age after which mirrors are fetched from upstream again, on every request if zero
+		MirrorTTL time.Duration
+
+		// Provides the credentials for pushing to push mirrors, none are used if nil
+		Secrets SecretStore
+
+		// Number of retries of failed pushes to push mirrors, 3 if zero and none if negative
+		PushMirrorRetries int
+
+		// Delay before retrying a failed push to a push mirror, doubled for each further retry,
+		// a minute if zero
+		PushMirrorRetryDelay time.Duration
+
+		// Time that deleted repositories are kept in the trash, they are deleted right away if zero
+		TrashRetention time.Duration
 	}
 )
 
@@ -90,12 +205,26 @@ func (g *gitContext) event(e Event) {
 	}
 }
 
+// Publish the event of a request with its repository, namespace, directory and identity
+func (g *gitContext) requestEvent(hr HandlerReq, e Event) {
+	e.Dir = hr.Dir
+	e.Repo = strings.TrimPrefix(hr.Repo, "/")
+	e.Namespace = hr.Namespace
+	e.Request = hr.r
+	e.Identity = RequestIdentity(hr.r)
+	g.event(e)
+}
+
 // Actual command handling functions
 
 func (g *gitContext) serviceRPC(hr HandlerReq) error {
 	w, r, rpc, dir := hr.w, hr.r, hr.RPC, hr.Dir
 
-	access, err := g.hasAccess(r, dir, rpc, true)
+	access, err := g.hasAccess(hr, rpc, true)
+	switch err.(type) {
+	case *ErrorRepoState, *ErrorMirror:
+		return g.rejectPush(hr, err, false)
+	}
 	if err != nil {
 		return err
 	}
@@ -104,6 +233,12 @@ func (g *gitContext) serviceRPC(hr HandlerReq) error {
 		return &ErrorNoAccess{hr.Dir}
 	}
 
+	// Maintenance waits for pushes and vice versa
+	if rpc == "receive-pack" {
+		g.locks.lockPush(dir)
+		defer g.locks.unlockPush(dir)
+	}
+
 	// Reader that decompresses if necessary
 	reader, err := requestReader(r)
 	if err != nil {
@@ -111,18 +246,94 @@ func (g *gitContext) serviceRPC(hr HandlerReq) error {
 	}
 	defer reader.Close()
 
+	// Refs hidden from the user
+	hidden, err := g.hiddenRefs(hr)
+	if err != nil {
+		return err
+	}
+
+	// Reject fetches of hidden refs
+	var body io.Reader = reader
+	if rpc == "upload-pack" && len(hidden) > 0 {
+		body, err = g.checkWants(dir, hr.Namespace, reader, hidden)
+		if hiddenErr, ok := err.(*ErrorHiddenRef); ok {
+			w.Header().Set("Content-Type", fmt.Sprintf("application/x-git-%s-result", rpc))
+			return writeRemoteError(w, hiddenErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Authorize the ref updates of pushes
+	var push *receivePackRequest
+	var denied []receivePackCommand
+	if rpc == "receive-pack" && g.options.RefAuthorizer != nil {
+		push, body, denied, err = g.authorizeRefs(hr, reader)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Limit the size of pushes
+	var quota *pushQuota
+	if rpc == "receive-pack" && body != nil {
+		quota, err = g.pushQuota(dir)
+		if err != nil {
+			return err
+		}
+		if quota != nil && push == nil {
+			push, err = readReceivePackRequest(body, hr.Repo)
+			if err != nil {
+				return err
+			}
+			body = io.MultiReader(bytes.NewReader(push.raw), body)
+		}
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-git-%s-result", rpc))
+
+	// Fire events for denied ref updates
+	defer func() {
+		for _, e := range deniedEvents(denied) {
+			g.requestEvent(hr, e)
+		}
+	}()
+
+	// Nothing left to do for git
+	if body == nil {
+		return writeDeniedReport(w, push, reader, denied)
+	}
+
 	// Reader that scans for events
 	rpcReader := &RpcReader{
-		Reader: reader,
+		Reader: body,
 		Rpc:    rpc,
 	}
+	if quota != nil {
+		// The repository is full already
+		if quota.size <= 0 {
+			g.quotaEvents(hr, push, quota.err)
+			return writeQuotaReport(w, push, body, denied, quota.err)
+		}
+		rpcReader.MaxSize = quota.size
+	}
 
-	// Set content type
-	w.Header().Set("Content-Type", fmt.Sprintf("application/x-git-%s-result", rpc))
+	// Snapshot of the refs, the journal records the updates git applies.
+	// Concurrent pushes to the repository wait, their updates would be attributed to this one.
+	var refs []gitRef
+	if rpc == "receive-pack" {
+		defer g.lockRefUpdates(dir)()
+		if refs, err = g.listRefs(dir, "refs/"); err != nil {
+			return err
+		}
+	}
 
-	args := []string{rpc, "--stateless-rpc", "."}
+	args := append(hideRefsArgs(hidden), rpc, "--stateless-rpc", ".")
 	cmd := exec.Command(g.options.GitBinPath, args...)
 	cmd.Dir = dir
+	cmd.Env = namespaceEnv(hr.Namespace)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return err
@@ -145,11 +356,23 @@ func (g *gitContext) serviceRPC(hr HandlerReq) error {
 	}
 
 	// Copy input to git binary
-	io.Copy(stdin, rpcReader)
+	_, err = io.Copy(stdin, rpcReader)
 	stdin.Close()
 
+	// Abort pushes that exceed their quota, git drops the objects it received so far
+	if err == ErrSizeLimit {
+		cmd.Process.Kill()
+		cmd.Wait()
+		g.quotaEvents(hr, push, quota.err)
+		return writeQuotaReport(w, push, body, denied, quota.err)
+	}
+
 	// Write git binary's output to http response
-	io.Copy(w, gitReader)
+	if push != nil {
+		injectRefStatus(w, gitReader, push, denied)
+	} else {
+		io.Copy(w, gitReader)
+	}
 
 	// Wait till command has completed
 	mainError := cmd.Wait()
@@ -158,15 +381,27 @@ func (g *gitContext) serviceRPC(hr HandlerReq) error {
 		mainError = gitReader.GitError
 	}
 
+	// Some refs may have been updated even if others failed
+	if rpc == "receive-pack" {
+		if err := g.journalRequest(hr, refs); err != nil {
+			g.requestEvent(hr, Event{Type: PUSH, Error: err})
+		}
+	}
+
 	// Fire events
 	for _, e := range rpcReader.Events {
-		// Set directory to current repo
-		e.Dir = dir
-		e.Request = hr.r
 		e.Error = mainError
+		g.requestEvent(hr, e)
+	}
 
-		// Fire event
-		g.event(e)
+	if rpc == "receive-pack" && mainError == nil {
+		// Namespaces get a HEAD with their first push
+		if hr.Namespace != "" {
+			g.ensureNamespaceHead(dir, hr.Namespace)
+		}
+
+		// Replicate successful pushes to the push mirrors
+		g.schedulePushMirrors(dir)
 	}
 
 	// Because a response was already written,
@@ -177,19 +412,46 @@ func (g *gitContext) serviceRPC(hr HandlerReq) error {
 func (g *gitContext) getInfoRefs(hr HandlerReq) error {
 	w, r, dir := hr.w, hr.r, hr.Dir
 	serviceName := getServiceType(r)
-	access, err := g.hasAccess(r, dir, serviceName, false)
+	access, err := g.hasAccess(hr, serviceName, false)
+	switch err.(type) {
+	case *ErrorRepoState, *ErrorMirror:
+		return g.rejectPush(hr, err, true)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Mirrors are served locally even if upstream is unavailable, the MIRROR event reports the failure
+	if access && serviceName == "upload-pack" {
+		upstream, err := g.upstream(hr.Repo)
+		if err != nil {
+			return err
+		}
+		if upstream != "" {
+			g.updateMirror(dir)
+		}
+	}
+
+	hidden, err := g.hiddenRefs(hr)
 	if err != nil {
 		return err
 	}
 
 	if !access {
+		// The dumb protocol can't hide refs
+		if len(hidden) > 0 || hr.Namespace != "" {
+			return &ErrorNoAccess{hr.Dir}
+		}
 		g.updateServerInfo(dir)
 		hdrNocache(w)
 		return sendFile("text/plain; charset=utf-8", hr)
 	}
 
-	args := []string{serviceName, "--stateless-rpc", "--advertise-refs", "."}
-	refs, err := g.gitCommand(dir, args...)
+	args := append(hideRefsArgs(hidden), serviceName, "--stateless-rpc", "--advertise-refs", ".")
+	cmd := exec.Command(g.options.GitBinPath, args...)
+	cmd.Dir = dir
+	cmd.Env = namespaceEnv(hr.Namespace)
+	refs, err := cmd.Output()
 	if err != nil {
 		return err
 	}
@@ -248,38 +510,14 @@ func sendFile(contentType string, hr HandlerReq) error {
 	return nil
 }
 
-func (g *gitContext) getGitDir(repoPath string) (targetPath string, err error) {
-	options := g.options
-	root := options.ProjectRoot
-
-	if root == "" {
-		cwd, err := os.Getwd()
-
-		if err != nil {
-			return "", err
-		}
-
-		root = cwd
-	}
-
-	var subDir string
-
+func (g *gitContext) getGitDir(r *http.Request, repoPath string, rpc string) (targetPath string, err error) {
 	// Create preprocessing context
-	var prepper = &Preprocesser{}
-	if options.Prep != nil {
-		optPrep := options.Prep()
-		prepper = &optPrep
-	}
+	prepper := g.preprocesser()
 
-	if !prepper.IsPathNil() {
-		subDir, err = prepper.Path(repoPath)
-		if err != nil {
-			return "", err
-		}
-	} else {
-		subDir = repoPath
+	localPath, err := g.localPath(prepper, repoPath)
+	if err != nil {
+		return "", err
 	}
-	localPath := path.Join(root, subDir)
 	absPath, err := filepath.Abs(localPath)
 	if err != nil {
 		return "", err
@@ -288,17 +526,24 @@ func (g *gitContext) getGitDir(repoPath string) (targetPath string, err error) {
 	var repo *gogit.Repository
 	if checkRepo, err := gogit.PlainOpen(absPath); err == nil {
 		repo = checkRepo
-	} else {
-		// If AutoCreate is false, just bail
-		if !options.AutoCreate {
+	} else if upstream, upstreamErr := g.upstream(repoPath); upstreamErr != nil {
+		return "", upstreamErr
+	} else if upstream != "" {
+		// Mirrors are cloned on first access
+		repo, isNew, err = g.createMirror(absPath, upstream)
+		if err != nil {
 			return "", err
 		}
-		// If AutoCreate is true, attempt to create and initialise the directory
-		err = os.MkdirAll(localPath, os.ModePerm)
-		if err != nil {
+	} else {
+		// If AutoCreate is false or not applicable, just bail
+		if create, createErr := g.mayCreate(r, repoPath, rpc); createErr != nil {
+			return "", createErr
+		} else if !create {
 			return "", err
 		}
-		repo, err = gogit.PlainInit(absPath, !options.NoBare)
+		// If AutoCreate is true, attempt to create and initialise the directory.
+		// Repositories created by a push stay empty, a seed commit would reject it as non-fast-forward.
+		repo, err = g.initRepo(absPath, repoPath, CreateOptions{Creator: RequestIdentity(r), Empty: isPush(r, rpc)})
 		if err != nil {
 			return "", err
 		}
@@ -310,6 +555,7 @@ func (g *gitContext) getGitDir(repoPath string) (targetPath string, err error) {
 			LocalPath:      absPath,
 			IsNew:          isNew,
 			Repository:     repo,
+			Identity:       RequestIdentity(r),
 		})
 		if err != nil {
 			return "", err
@@ -319,7 +565,101 @@ func (g *gitContext) getGitDir(repoPath string) (targetPath string, err error) {
 	return localPath, nil
 }
 
-func (g *gitContext) hasAccess(r *http.Request, dir string, rpc string, checkContentType bool) (bool, error) {
+// mayCreate returns true if the AutoCreate policy allows a request to create its repository.
+func (g *gitContext) mayCreate(r *http.Request, repoPath string, rpc string) (bool, error) {
+	options := g.options
+	if !options.AutoCreate {
+		return false, nil
+	}
+	if options.AutoCreateOnPush {
+		if !isPush(r, rpc) {
+			return false, nil
+		}
+		// The push must be enabled, new repositories have no http.receivepack setting yet
+		enabled := options.ReceivePack
+		if options.AccessResolver != nil {
+			var err error
+			if enabled, err = options.AccessResolver(repoPath, "receive-pack", r); err != nil {
+				return false, err
+			}
+		}
+		if !enabled {
+			return false, nil
+		}
+	}
+	if options.AutoCreateAuthorizer != nil {
+		return options.AutoCreateAuthorizer(RequestIdentity(r), repoPath)
+	}
+	return true, nil
+}
+
+// isPush returns true for the receive-pack requests of a push, including its ref advertisement.
+func isPush(r *http.Request, rpc string) bool {
+	// The service parameter is only read from the query of info/refs requests
+	return rpc == "receive-pack" || (rpc == "" && getServiceType(r) == "receive-pack")
+}
+
+// preprocesser creates the preprocessing context of a request.
+func (g *gitContext) preprocesser() *Preprocesser {
+	if g.options.Prep == nil {
+		return &Preprocesser{}
+	}
+	prepper := g.options.Prep()
+	return &prepper
+}
+
+// localPath resolves the local path of a repository below the project root.
+func (g *gitContext) localPath(prepper *Preprocesser, repoPath string) (string, error) {
+	root := g.options.ProjectRoot
+
+	if root == "" {
+		cwd, err := os.Getwd()
+
+		if err != nil {
+			return "", err
+		}
+
+		root = cwd
+	}
+
+	subDir := repoPath
+	if !prepper.IsPathNil() {
+		var err error
+		subDir, err = prepper.Path(repoPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Repositories must not escape the project root or enter the metadata directory
+	localPath := path.Join(root, subDir)
+	if rel, err := filepath.Rel(root, localPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) ||
+		rel == metaDir || strings.HasPrefix(rel, metaDir+string(filepath.Separator)) {
+		return "", ErrInvalidRepoPath
+	}
+	return localPath, nil
+}
+
+// root returns the absolute project root.
+func (g *gitContext) root() (string, error) {
+	return filepath.Abs(g.options.ProjectRoot)
+}
+
+// RepoDir resolves the absolute local directory of a repository path
+// like git requests do, but without creating the repository.
+func (g *gitContext) RepoDir(repoPath string) (string, error) {
+	localPath, err := g.localPath(g.preprocesser(), repoPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(localPath)
+}
+
+// hasAccess decides if a service is enabled for a repository. The http.uploadpack and http.receivepack
+// settings of the repository take precedence like in git-http-backend, then the AccessResolver decides,
+// then the global UploadPack and ReceivePack options.
+func (g *gitContext) hasAccess(hr HandlerReq, rpc string, checkContentType bool) (bool, error) {
+	r, dir := hr.r, hr.Dir
 	if checkContentType {
 		if r.Header.Get("Content-Type") != fmt.Sprintf("application/x-git-%s-request", rpc) {
 			return false, nil
@@ -329,28 +669,53 @@ func (g *gitContext) hasAccess(r *http.Request, dir string, rpc string, checkCon
 	if !(rpc == "upload-pack" || rpc == "receive-pack") {
 		return false, nil
 	}
-	if rpc == "receive-pack" {
-		return g.options.ReceivePack, nil
+
+	access, ok, err := g.getConfigSetting(rpc, dir)
+	if err != nil {
+		return false, err
 	}
-	if rpc == "upload-pack" {
-		return g.options.UploadPack, nil
+	if !ok && g.options.AccessResolver != nil {
+		access, err = g.options.AccessResolver(hr.Repo, rpc, r)
+		if err != nil {
+			return false, err
+		}
+		ok = true
+	}
+	if !ok {
+		access = g.options.UploadPack
+		if rpc == "receive-pack" {
+			access = g.options.ReceivePack
+		}
 	}
 
-	return g.getConfigSetting(rpc, dir)
+	// Mirrors, read-only and archived repositories reject pushes
+	if access && rpc == "receive-pack" {
+		upstream, err := g.upstream(hr.Repo)
+		if err != nil {
+			return false, err
+		}
+		if upstream != "" {
+			return false, &ErrorMirror{Dir: dir}
+		}
+		if err := g.checkPushState(dir); err != nil {
+			return false, err
+		}
+	}
+	return access, nil
 }
 
-func (g *gitContext) getConfigSetting(serviceName string, dir string) (bool, error) {
+// getConfigSetting reads the http.uploadpack or http.receivepack setting of a repository,
+// ok is false if it isn't set.
+func (g *gitContext) getConfigSetting(serviceName string, dir string) (access bool, ok bool, err error) {
 	serviceName = strings.Replace(serviceName, "-", "", -1)
-	setting, err := g.getGitConfig("http."+serviceName, dir)
-	if err != nil {
-		return false, nil
+	setting, err := g.gitCommand(dir, "config", "--bool", "http."+serviceName)
+	if exitCode(err) == 1 {
+		return false, false, nil
 	}
-
-	if serviceName == "uploadpack" {
-		return setting != "false", nil
+	if err != nil {
+		return false, false, err
 	}
-
-	return setting == "true", nil
+	return strings.TrimSpace(string(setting)) == "true", true, nil
 }
 
 func (g *gitContext) getGitConfig(configName string, dir string) (string, error) {
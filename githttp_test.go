@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -16,9 +17,10 @@ import (
 )
 
 const (
-	gitRefs    = "/info/refs?service="
-	uploadPack = "git-upload-pack"
-	testFile   = "test.file"
+	gitRefs     = "/info/refs?service="
+	uploadPack  = "git-upload-pack"
+	receivePack = "git-receive-pack"
+	testFile    = "test.file"
 )
 
 func TestNewGitContext(t *testing.T) {
@@ -257,3 +259,22 @@ func initRepo(path string, bare bool, withTestFile bool) (*git.Repository, error
 	}
 	return repo, nil
 }
+
+// runGit executes the git binary in dir and returns its combined output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// pushTestRepo creates a working copy with a single commit and pushes it to remote.
+func pushTestRepo(path string, remote string, refspecs ...string) (string, error) {
+	if _, err := initRepo(path, false, true); err != nil {
+		return "", err
+	}
+	if len(refspecs) == 0 {
+		refspecs = []string{"master"}
+	}
+	return runGit(path, append([]string{"push", remote}, refspecs...)...)
+}
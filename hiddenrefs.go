@@ -0,0 +1,98 @@
+package githttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// hiddenRefs returns the ref prefixes that are hidden from the user of the request.
+func (g *gitContext) hiddenRefs(hr HandlerReq) ([]string, error) {
+	if g.options.HiddenRefs == nil {
+		return nil, nil
+	}
+	return g.options.HiddenRefs(RequestIdentity(hr.r), hr.Repo)
+}
+
+// hideRefsArgs returns the git options that hide the given ref prefixes
+// from ref advertisements and fetches.
+func hideRefsArgs(hidden []string) []string {
+	var args []string
+	for _, prefix := range hidden {
+		args = append(args, "-c", "transfer.hideRefs="+strings.TrimSuffix(prefix, "/"))
+	}
+	return args
+}
+
+// isHiddenRef returns true if the ref matches one of the hidden prefixes,
+// following the semantics of transfer.hideRefs.
+func isHiddenRef(ref string, hidden []string) bool {
+	for _, prefix := range hidden {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if ref == prefix || strings.HasPrefix(ref, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenTips returns the object ids that are the tip of hidden refs only.
+// Only the refs of the namespace count, if any.
+func (g *gitContext) hiddenTips(dir string, namespace string, hidden []string) (map[string]bool, error) {
+	args := []string{"for-each-ref", "--format=%(objectname) %(refname)"}
+	prefix := namespacePrefix(namespace)
+	if prefix != "" {
+		args = append(args, prefix)
+	}
+	out, err := g.gitCommand(dir, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	hiddenTips := map[string]bool{}
+	visibleTips := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if isHiddenRef(strings.TrimPrefix(fields[1], prefix), hidden) {
+			hiddenTips[fields[0]] = true
+		} else {
+			visibleTips[fields[0]] = true
+		}
+	}
+	for id := range visibleTips {
+		delete(hiddenTips, id)
+	}
+	return hiddenTips, nil
+}
+
+// checkWants reads the want section of an upload-pack request and rejects
+// wants for tips of hidden refs. It returns the request body to pass on to git.
+func (g *gitContext) checkWants(dir string, namespace string, body io.Reader, hidden []string) (io.Reader, error) {
+	lines, raw, err := packetReadSection(body)
+	if err != nil {
+		return nil, err
+	}
+
+	tips, err := g.hiddenTips(dir, namespace, hidden)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "want" && tips[fields[1]] {
+			return nil, &ErrorHiddenRef{fields[1]}
+		}
+	}
+
+	return io.MultiReader(bytes.NewReader(raw), body), nil
+}
+
+// writeRemoteError responds with an error that git clients print to the user.
+func writeRemoteError(w io.Writer, err error) error {
+	_, werr := w.Write(packetWrite(fmt.Sprintf("ERR %s\n", err)))
+	return werr
+}
@@ -0,0 +1,108 @@
+package githttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHiddenRefs(t *testing.T) {
+	defer os.RemoveAll("./testdata/hiddenrefs")
+
+	hide := false
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/hiddenrefs/server",
+		AutoCreate:  true,
+		ReceivePack: true,
+		UploadPack:  true,
+		HiddenRefs: func(identity *Identity, repo string) ([]string, error) {
+			if !hide {
+				return nil, nil
+			}
+			return []string{"refs/heads/security"}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	client := "./testdata/hiddenrefs/client"
+	if out, err := pushTestRepo(client, server.URL+"/repo"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(client, "-c", "user.name=Jane", "-c", "user.email=jane@doe.org", "commit", "--allow-empty", "-m", "secret"); err != nil {
+		t.Fatalf("commit failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(client, "push", server.URL+"/repo", "HEAD:refs/heads/security/fix"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	secret, _ := runGit(client, "rev-parse", "HEAD")
+	secret = strings.TrimSpace(secret)
+
+	hide = true
+
+	// Hidden refs are not advertised
+	out, err := runGit(client, "ls-remote", server.URL+"/repo")
+	if err != nil {
+		t.Fatalf("ls-remote failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "refs/heads/master") || strings.Contains(out, "security") {
+		t.Errorf("unexpected advertisement:\n%s", out)
+	}
+
+	// Hidden tips can't be fetched
+	body := string(packetWrite(fmt.Sprintf("want %s no-progress\n", secret))) + string(packetFlush()) + string(packetWrite("done\n"))
+	req := httptest.NewRequest("POST", "/repo/git-upload-pack", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	rr := httptest.NewRecorder()
+	git.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "ERR upload-pack: not our ref "+secret) {
+		t.Errorf("want of hidden tip should have been rejected: %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Dumb clients can't get a filtered ref list
+	req = httptest.NewRequest("GET", "/repo/info/refs", nil)
+	rr = httptest.NewRecorder()
+	git.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("dumb info/refs: got %d, want %d", rr.Code, http.StatusForbidden)
+	}
+
+	// Nor their objects and packs
+	loose := "/repo/objects/" + secret[:2] + "/" + secret[2:]
+	for _, path := range []string{loose, "/repo/HEAD", "/repo/objects/info/packs"} {
+		rr = httptest.NewRecorder()
+		git.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("dumb %s: got %d, want %d", path, rr.Code, http.StatusNotFound)
+		}
+	}
+	hide = false
+	rr = httptest.NewRecorder()
+	git.ServeHTTP(rr, httptest.NewRequest("GET", loose, nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("dumb %s without hidden refs: got %d, want %d", loose, rr.Code, http.StatusOK)
+	}
+}
+
+func TestIsHiddenRef(t *testing.T) {
+	hidden := []string{"refs/heads/security", "refs/namespaces/other/"}
+	tests := map[string]bool{
+		"refs/heads/security":           true,
+		"refs/heads/security/fix":       true,
+		"refs/heads/securityfix":        false,
+		"refs/namespaces/other/refs/x":  true,
+		"refs/namespaces/others/refs/x": false,
+		"refs/heads/master":             false,
+	}
+	for ref, want := range tests {
+		if got := isHiddenRef(ref, hidden); got != want {
+			t.Errorf("isHiddenRef(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
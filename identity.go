@@ -0,0 +1,55 @@
+package githttp
+
+import (
+	"context"
+	"net/http"
+)
+
+// Identity is the authenticated user behind a request.
+type Identity struct {
+	// Username or email
+	Username string `json:"username"`
+
+	// Groups the user belongs to
+	Groups []string `json:"groups,omitempty"`
+
+	// Authentication scheme, e.g. "basic" or "certificate"
+	Scheme string `json:"scheme,omitempty"`
+
+	// ID of the token that was used to authenticate (if any)
+	TokenID string `json:"tokenId,omitempty"`
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx that carries the identity.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity stored in ctx, or nil for anonymous requests.
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// RequestIdentity returns the identity of an authenticated request, or nil for anonymous requests.
+func RequestIdentity(r *http.Request) *Identity {
+	if r == nil {
+		return nil
+	}
+	return IdentityFromContext(r.Context())
+}
+
+// InGroup returns true if the identity is a member of the group.
+func (i *Identity) InGroup(group string) bool {
+	if i == nil {
+		return false
+	}
+	for _, g := range i.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package githttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestEventIdentity(t *testing.T) {
+	defer os.RemoveAll("./testdata/identity")
+
+	identity := &Identity{Username: "jane", Scheme: "basic"}
+	var events []Event
+	var processed *Identity
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/identity/server",
+		AutoCreate:  true,
+		ReceivePack: true,
+		UploadPack:  true,
+		EventHandler: func(ev Event) {
+			events = append(events, ev)
+		},
+		Prep: func() Preprocesser {
+			return Preprocesser{
+				Process: func(params *ProcessParams) error {
+					processed = params.Identity
+					return nil
+				},
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		git.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+	}))
+	defer server.Close()
+
+	if out, err := pushTestRepo("./testdata/identity/client", server.URL+"/repo"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+
+	if processed != identity {
+		t.Errorf("Preprocesser got identity %+v, want %+v", processed, identity)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if events[0].Type != PUSH || events[0].Identity != identity {
+		t.Errorf("got event %+v, want push by %+v", events[0], identity)
+	}
+}
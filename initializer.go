@@ -15,6 +15,8 @@ type (
 		IsNew bool
 		// The gogit repository
 		Repository *gogit.Repository
+		// The authenticated user of the request (nil if anonymous)
+		Identity *Identity
 	}
 
 	// Preprocesser is called on every git request.
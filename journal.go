@@ -0,0 +1,279 @@
+package githttp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+const (
+	// journalFile is the ref journal in the git directory of a repository, one JSON encoded RefChange per line
+	journalFile = "githttp-journal"
+	// keepAroundPrefix is the prefix of the refs that keep overwritten ref values alive, hidden from clients
+	keepAroundPrefix = "refs/keep-around/"
+)
+
+// RefChange is an update of a ref that githttp applied, recorded in the journal of the repository.
+// Unlike reflogs, the journal never expires and the objects of all values are kept.
+type RefChange struct {
+	// Full name of the ref, e.g. refs/heads/master
+	Ref string `json:"ref"`
+	// Previous and new object id, ZeroID for creations and deletions
+	Old string `json:"old"`
+	New string `json:"new"`
+	// User that updated the ref, empty if anonymous
+	User string    `json:"user,omitempty"`
+	Time time.Time `json:"time"`
+	// X-Request-Id header of the request, generated if it had none
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// RefHistory returns the recorded changes of a ref, the most recent first.
+// Refs of namespaced repositories are resolved within their namespace.
+func (g *gitContext) RefHistory(repoPath string, ref string) ([]RefChange, error) {
+	dir, namespace, err := g.journalRepo(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	prefix := namespacePrefix(namespace)
+	history, err := readJournal(dir, prefix+ref)
+	if err != nil {
+		return nil, err
+	}
+	for i := range history {
+		history[i].Ref = ref
+	}
+	return history, nil
+}
+
+// RestoreRef sets a ref to an earlier value from its history, ZeroID deletes it.
+// The restore is recorded in the journal like other updates.
+func (g *gitContext) RestoreRef(repoPath string, ref string, id string, identity *Identity, requestID string) error {
+	dir, namespace, err := g.journalRepo(repoPath)
+	if err != nil {
+		return err
+	}
+	physicalRef := namespacePrefix(namespace) + ref
+
+	g.locks.lockPush(dir)
+	defer g.locks.unlockPush(dir)
+	defer g.lockRefUpdates(dir)()
+
+	history, err := readJournal(dir, physicalRef)
+	if err != nil {
+		return err
+	}
+	recorded := false
+	for _, change := range history {
+		if change.Old == id || change.New == id {
+			recorded = true
+			break
+		}
+	}
+	if !recorded {
+		return ErrRefValueNotFound
+	}
+
+	current := ZeroID
+	if out, err := g.gitCommand(dir, "rev-parse", "-q", "--verify", physicalRef); err == nil {
+		current = strings.TrimSpace(string(out))
+	}
+	if current == id {
+		return nil
+	}
+	if id == ZeroID {
+		_, err = g.gitCommand(dir, "update-ref", "-d", physicalRef, current)
+	} else {
+		_, err = g.gitCommand(dir, "update-ref", physicalRef, id, current)
+	}
+	if err != nil {
+		return err
+	}
+
+	change := RefChange{Ref: physicalRef, Old: current, New: id, Time: time.Now().UTC(), RequestID: requestID}
+	if identity != nil {
+		change.User = identity.Username
+	}
+	for _, e := range scanPush(current + " " + id + " " + ref) {
+		e.Dir = dir
+		e.Repo = strings.Trim(repoPath, "/")
+		e.Namespace = namespace
+		e.Identity = identity
+		g.event(e)
+	}
+	return g.journal(dir, []RefChange{change})
+}
+
+// journalRepo resolves the directory of the physical repository that keeps the journal of a repository,
+// and its namespace within.
+func (g *gitContext) journalRepo(repoPath string) (string, string, error) {
+	physical, namespace, err := g.resolveNamespace("/" + strings.Trim(repoPath, "/"))
+	if err != nil {
+		return "", "", err
+	}
+	dir, err := g.managedDir(physical)
+	if err != nil {
+		return "", "", err
+	}
+	if !isRepoDir(dir) {
+		return "", "", gogit.ErrRepositoryNotExists
+	}
+	return dir, namespace, nil
+}
+
+// lockRefUpdates serializes the ref updates of a repository, so that the snapshots of a request
+// only differ by its own updates. It returns the unlock function.
+func (g *gitContext) lockRefUpdates(dir string) func() {
+	mu, _ := g.refUpdates.LoadOrStore(lockKey(dir), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// journalRequest records the ref changes that a request applied since the snapshot of the refs before.
+func (g *gitContext) journalRequest(hr HandlerReq, before []gitRef) error {
+	after, err := g.listRefs(hr.Dir, "refs/")
+	if err != nil {
+		return err
+	}
+	changes := refChanges(before, after)
+	user := ""
+	if identity := RequestIdentity(hr.r); identity != nil {
+		user = identity.Username
+	}
+	id := requestID(hr.r)
+	now := time.Now().UTC()
+	for i := range changes {
+		changes[i].User = user
+		changes[i].Time = now
+		changes[i].RequestID = id
+	}
+	return g.journal(hr.Dir, changes)
+}
+
+// journal appends ref changes to the journal of a repository, the caller must hold its push lock.
+// Values that aren't reachable from the new value of their ref anymore are kept alive by a keep-around ref.
+func (g *gitContext) journal(dir string, changes []RefChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, change := range changes {
+		if change.Old == ZeroID {
+			continue
+		}
+		if change.New != ZeroID {
+			if _, err := g.gitCommand(dir, "merge-base", "--is-ancestor", change.Old, change.New); err == nil {
+				continue
+			}
+		}
+		if err := g.keepAround(dir, change.Old); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(gitDir(dir), journalFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, change := range changes {
+		if err := encoder.Encode(change); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// keepAround protects an object and the ones it references from gc by a ref,
+// which the repository hides from clients.
+func (g *gitContext) keepAround(dir string, id string) error {
+	hidden, err := g.gitCommand(dir, "config", "--get-all", "transfer.hideRefs")
+	if err != nil && exitCode(err) != 1 {
+		return err
+	}
+	prefix := strings.TrimSuffix(keepAroundPrefix, "/")
+	if !isHiddenRef(keepAroundPrefix+id, strings.Fields(string(hidden))) {
+		if _, err := g.gitCommand(dir, "config", "--add", "transfer.hideRefs", prefix); err != nil {
+			return err
+		}
+	}
+	_, err = g.gitCommand(dir, "update-ref", keepAroundPrefix+id, id)
+	return err
+}
+
+// refChanges returns the changes between two snapshots of the refs of a repository,
+// except for keep-around refs.
+func refChanges(before []gitRef, after []gitRef) []RefChange {
+	old := map[string]string{}
+	for _, ref := range before {
+		old[ref.name] = ref.id
+	}
+	var changes []RefChange
+	for _, ref := range after {
+		if id, ok := old[ref.name]; !ok {
+			changes = append(changes, RefChange{Ref: ref.name, Old: ZeroID, New: ref.id})
+		} else if id != ref.id {
+			changes = append(changes, RefChange{Ref: ref.name, Old: id, New: ref.id})
+		}
+		delete(old, ref.name)
+	}
+	for _, ref := range before {
+		if _, ok := old[ref.name]; ok {
+			changes = append(changes, RefChange{Ref: ref.name, Old: ref.id, New: ZeroID})
+		}
+	}
+
+	kept := changes[:0]
+	for _, change := range changes {
+		if !strings.HasPrefix(change.Ref, keepAroundPrefix) {
+			kept = append(kept, change)
+		}
+	}
+	return kept
+}
+
+// readJournal returns the recorded changes of a ref, the most recent first.
+func readJournal(dir string, ref string) ([]RefChange, error) {
+	changes := []RefChange{}
+	file, err := os.Open(filepath.Join(gitDir(dir), journalFile))
+	if os.IsNotExist(err) {
+		return changes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var change RefChange
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			return nil, err
+		}
+		if change.Ref == ref {
+			changes = append([]RefChange{change}, changes...)
+		}
+	}
+	return changes, scanner.Err()
+}
+
+// requestID returns the X-Request-Id header of a request, or a random id if it has none.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(random)
+}
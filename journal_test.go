@@ -0,0 +1,202 @@
+package githttp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRefJournal(t *testing.T) {
+	defer os.RemoveAll("./testdata/journal")
+
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/journal/server",
+		AutoCreate:  true,
+		UploadPack:  true,
+		ReceivePack: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		git.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), &Identity{Username: "alice"})))
+	}))
+	defer server.Close()
+
+	client, repo := "./testdata/journal/client", "./testdata/journal/server/repo"
+	if out, err := pushTestRepo(client, server.URL+"/repo"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	first, _ := runGit(client, "rev-parse", "master")
+	first = strings.TrimSpace(first)
+	if err := ioutil.WriteFile(filepath.Join(client, testFile), []byte("update"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(client, "-c", "user.name=test", "-c", "user.email=test@example.org", "commit", "-am", "update"); err != nil {
+		t.Fatalf("commit failed: %v\n%s", err, out)
+	}
+	second, _ := runGit(client, "rev-parse", "master")
+	second = strings.TrimSpace(second)
+	if out, err := runGit(client, "push", server.URL+"/repo", "master"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+
+	// A mistaken force push, its old value survives the expiry of the reflog and gc
+	if out, err := runGit(client, "push", "--force", server.URL+"/repo", "master~1:master"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(repo, "reflog", "expire", "--expire=now", "--all"); err != nil {
+		t.Fatalf("reflog expire failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(repo, "gc", "--prune=now", "--quiet"); err != nil {
+		t.Fatalf("gc failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(repo, "cat-file", "-e", second); err != nil {
+		t.Errorf("overwritten commit was pruned: %v\n%s", err, out)
+	}
+	if out, _ := runGit(client, "ls-remote", server.URL+"/repo"); strings.Contains(out, keepAroundPrefix) {
+		t.Errorf("keep-around refs are advertised:\n%s", out)
+	}
+
+	history, err := git.RefHistory("repo", "refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{{second, first}, {first, second}, {ZeroID, first}}
+	if len(history) != len(want) {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	for i, change := range history {
+		if change.Old != want[i][0] || change.New != want[i][1] || change.User != "alice" || change.RequestID == "" || change.Time.IsZero() {
+			t.Errorf("unexpected change %d: %+v", i, change)
+		}
+	}
+
+	// Restores are recorded as well
+	if err := git.RestoreRef("repo", "refs/heads/master", "1234567890123456789012345678901234567890", nil, ""); err != ErrRefValueNotFound {
+		t.Errorf("got %v, want ErrRefValueNotFound", err)
+	}
+	if err := git.RestoreRef("repo", "refs/heads/master", second, &Identity{Username: "bob"}, "req-1"); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := runGit(repo, "rev-parse", "master"); strings.TrimSpace(out) != second {
+		t.Errorf("master is %q, want %q", out, second)
+	}
+	history, err = git.RefHistory("repo", "refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore := RefChange{Ref: "refs/heads/master", Old: first, New: second, User: "bob", Time: history[0].Time, RequestID: "req-1"}
+	if len(history) != 4 || history[0] != restore {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	// Restoring the value before the creation deletes the ref
+	if err := git.RestoreRef("repo", "refs/heads/master", ZeroID, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(repo, "rev-parse", "-q", "--verify", "master"); err == nil {
+		t.Errorf("master wasn't deleted: %s", out)
+	}
+	if out, err := runGit(repo, "rev-parse", "-q", "--verify", keepAroundPrefix+second); err != nil {
+		t.Errorf("deleted value isn't kept: %v\n%s", err, out)
+	}
+	if _, err := git.RefHistory("missing", "refs/heads/master"); err == nil {
+		t.Error("history of missing repository")
+	}
+}
+
+func TestRefJournalConcurrentPushes(t *testing.T) {
+	defer os.RemoveAll("./testdata/journalconcurrent")
+
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/journalconcurrent/server",
+		UploadPack:  true,
+		ReceivePack: true,
+		// /shared/team keeps its refs in a namespace of /shared
+		Namespace: func(repo string) (string, string, error) {
+			if strings.HasPrefix(repo, "/shared/") {
+				return "/shared", strings.TrimPrefix(repo, "/shared/"), nil
+			}
+			return repo, "", nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := &Identity{Username: r.Header.Get("X-User")}
+		git.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+	}))
+	defer server.Close()
+
+	for _, repo := range []string{"repo", "shared"} {
+		if _, err := git.CreateRepo(repo, CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := "./testdata/journalconcurrent/client"
+	if _, err := initRepo(client, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each push only records its own update
+	users := []string{"alice", "bob", "carol", "dave"}
+	errs := make(chan error, len(users))
+	for _, user := range users {
+		go func(user string) {
+			out, err := runGit(client, "-c", "http.extraHeader=X-User: "+user, "push", server.URL+"/repo", "master:"+user)
+			if err != nil {
+				err = fmt.Errorf("push of %s failed: %v\n%s", user, err, out)
+			}
+			errs <- err
+		}(user)
+	}
+	for range users {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, user := range users {
+		history, err := git.RefHistory("repo", "refs/heads/"+user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 1 || history[0].User != user || history[0].Old != ZeroID {
+			t.Errorf("unexpected history of %s: %+v", user, history)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join("./testdata/journalconcurrent/server/repo", journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != len(users) {
+		t.Errorf("journal has %d entries, want %d", lines, len(users))
+	}
+
+	// Namespaced repositories resolve their refs within the namespace
+	if out, err := runGit(client, "-c", "http.extraHeader=X-User: erin", "push", server.URL+"/shared/team", "master"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	history, err := git.RefHistory("shared/team", "refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Ref != "refs/heads/master" || history[0].User != "erin" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	if err := git.RestoreRef("shared/team", "refs/heads/master", ZeroID, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit("./testdata/journalconcurrent/server/shared", "rev-parse", "-q", "--verify", "refs/namespaces/team/refs/heads/master"); err == nil {
+		t.Errorf("namespaced ref wasn't deleted: %s", out)
+	}
+	if history, err := git.RefHistory("shared/team", "refs/heads/master"); err != nil || len(history) != 2 {
+		t.Errorf("unexpected history %+v: %v", history, err)
+	}
+}
@@ -0,0 +1,298 @@
+package githttp
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+// Default maintenance settings
+const (
+	defaultMaintenanceInterval = 24 * time.Hour
+	// Like gc.auto
+	defaultLooseObjects = 6700
+	// Like gc.autoPackLimit
+	defaultPacks = 50
+	// Like gc.pruneExpire
+	defaultPruneExpire = "2.weeks.ago"
+)
+
+// MaintenanceOptions configures the background maintenance of repositories.
+type MaintenanceOptions struct {
+	// Time between two runs over all repositories, a day if zero
+	Interval time.Duration
+
+	// Maximum number of repositories maintained at once, one if zero
+	Concurrency int
+
+	// Number of loose objects that triggers the maintenance of a repository, 6700 if zero
+	LooseObjects int
+
+	// Number of packs that triggers the maintenance of a repository, 50 if zero
+	Packs int
+}
+
+// RunMaintenance maintains the repositories under the project root whose loose objects or packs
+// exceed the triggers, once immediately and then every interval until the context is done.
+// Repositories that are being pushed to are skipped until the next run.
+func (g *gitContext) RunMaintenance(ctx context.Context) error {
+	interval := g.options.Maintenance.Interval
+	if interval <= 0 {
+		interval = defaultMaintenanceInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := g.maintainAll(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// MaintainRepo runs the maintenance of a repository regardless of the triggers.
+// It returns ErrRepoBusy while the repository is being pushed to.
+func (g *gitContext) MaintainRepo(repoPath string) error {
+	dir, err := g.managedDir(repoPath)
+	if err != nil {
+		return err
+	}
+	if !isRepoDir(dir) {
+		return gogit.ErrRepositoryNotExists
+	}
+	return g.maintain(dir)
+}
+
+// maintainAll maintains the due repositories, at most Concurrency at once.
+func (g *gitContext) maintainAll(ctx context.Context) error {
+	root, err := g.root()
+	if err != nil {
+		return err
+	}
+	repos, err := g.ListRepos()
+	if err != nil {
+		return err
+	}
+
+	concurrency := g.options.Maintenance.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, repo := range repos {
+		select {
+		case <-ctx.Done():
+			return nil
+		case slots <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(dir string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			due, err := g.maintenanceDue(dir)
+			if err != nil {
+				g.event(Event{Type: MAINTENANCE, Dir: dir, Error: err})
+				return
+			}
+			if due {
+				g.maintain(dir)
+			}
+		}(filepath.Join(root, filepath.FromSlash(repo)))
+	}
+	return nil
+}
+
+// maintenanceDue returns true if the loose objects or packs of a repository exceed the triggers.
+func (g *gitContext) maintenanceDue(dir string) (bool, error) {
+	looseLimit := g.options.Maintenance.LooseObjects
+	if looseLimit <= 0 {
+		looseLimit = defaultLooseObjects
+	}
+	packLimit := g.options.Maintenance.Packs
+	if packLimit <= 0 {
+		packLimit = defaultPacks
+	}
+	loose, packs, err := countObjects(dir)
+	if err != nil {
+		return false, err
+	}
+	return loose >= looseLimit || packs >= packLimit, nil
+}
+
+// maintain runs the maintenance tasks on a repository and fires a MAINTENANCE event.
+func (g *gitContext) maintain(dir string) error {
+	if !g.locks.tryLockMaintenance(dir) {
+		return ErrRepoBusy
+	}
+	defer g.locks.unlockMaintenance(dir)
+
+	err := g.runMaintenanceTasks(dir)
+	g.event(Event{Type: MAINTENANCE, Dir: dir, Error: err})
+	return err
+}
+
+func (g *gitContext) runMaintenanceTasks(dir string) error {
+	pruneExpire, err := g.getGitConfig("gc.pruneExpire", dir)
+	if exitCode(err) == 1 {
+		pruneExpire, err = defaultPruneExpire, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Objects of forks are never copied from the alternates of their parents (-l).
+	// Parents keep unreachable objects since forks may use them, everyone else loosens them for prune.
+	repack := []string{"repack", "-d", "-l", "--write-bitmap-index"}
+	if pruneExpire == "never" {
+		repack = append(repack, "-a", "--keep-unreachable")
+	} else {
+		repack = append(repack, "-A")
+	}
+	tasks := []struct {
+		name string
+		args []string
+	}{
+		{"gc", []string{"-c", "gc.autoDetach=false", "gc", "--auto", "--quiet"}},
+		{"repack", repack},
+		{"commit-graph", []string{"commit-graph", "write", "--reachable"}},
+		{"multi-pack-index", []string{"multi-pack-index", "write"}},
+	}
+	for _, task := range tasks {
+		if _, err := g.gitCommand(dir, task.args...); err != nil {
+			return &ErrorMaintenance{Dir: dir, Task: task.name, Err: err}
+		}
+	}
+	if pruneExpire != "never" {
+		if _, err := g.gitCommand(dir, "prune", "--expire", pruneExpire); err != nil {
+			return &ErrorMaintenance{Dir: dir, Task: "prune", Err: err}
+		}
+	}
+	return nil
+}
+
+// countObjects returns the number of loose objects and packs of a repository.
+func countObjects(dir string) (int, int, error) {
+	objects := filepath.Join(gitDir(dir), "objects")
+	entries, err := ioutil.ReadDir(objects)
+	if err != nil {
+		return 0, 0, err
+	}
+	var loose int
+	for _, entry := range entries {
+		if !entry.IsDir() || len(entry.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(objects, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, 0, err
+		}
+		loose += len(files)
+	}
+	packs, err := filepath.Glob(filepath.Join(objects, "pack", "*.pack"))
+	if err != nil {
+		return 0, 0, err
+	}
+	return loose, len(packs), nil
+}
+
+// repoLocks keeps maintenance and pushes of the same repository apart.
+// Pushes wait for running maintenance, maintenance skips repositories that are being pushed to.
+type repoLocks struct {
+	mu    sync.Mutex
+	repos map[string]*repoLock
+}
+
+type repoLock struct {
+	// Number of running pushes
+	pushes int
+	// Closed when the running maintenance is done, nil if none is running
+	maintenance chan struct{}
+}
+
+// lockKey returns the absolute directory of a repository, which may be relative in requests.
+func lockKey(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return filepath.Clean(dir)
+}
+
+func (l *repoLocks) get(dir string) *repoLock {
+	if l.repos == nil {
+		l.repos = map[string]*repoLock{}
+	}
+	lock, ok := l.repos[dir]
+	if !ok {
+		lock = &repoLock{}
+		l.repos[dir] = lock
+	}
+	return lock
+}
+
+// release forgets the lock of a repository once it is unused.
+func (l *repoLocks) release(dir string, lock *repoLock) {
+	if lock.pushes == 0 && lock.maintenance == nil {
+		delete(l.repos, dir)
+	}
+}
+
+func (l *repoLocks) lockPush(dir string) {
+	dir = lockKey(dir)
+	for {
+		l.mu.Lock()
+		lock := l.get(dir)
+		done := lock.maintenance
+		if done == nil {
+			lock.pushes++
+			l.mu.Unlock()
+			return
+		}
+		l.mu.Unlock()
+		<-done
+	}
+}
+
+func (l *repoLocks) unlockPush(dir string) {
+	dir = lockKey(dir)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock := l.get(dir)
+	lock.pushes--
+	l.release(dir, lock)
+}
+
+func (l *repoLocks) tryLockMaintenance(dir string) bool {
+	dir = lockKey(dir)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock := l.get(dir)
+	if lock.pushes > 0 || lock.maintenance != nil {
+		return false
+	}
+	lock.maintenance = make(chan struct{})
+	return true
+}
+
+func (l *repoLocks) unlockMaintenance(dir string) {
+	dir = lockKey(dir)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock := l.get(dir)
+	close(lock.maintenance)
+	lock.maintenance = nil
+	l.release(dir, lock)
+}
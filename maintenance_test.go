@@ -0,0 +1,104 @@
+package githttp
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaintenance(t *testing.T) {
+	defer os.RemoveAll("./testdata/maintenance")
+
+	var events []Event
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/maintenance/server",
+		AutoCreate:  true,
+		ReceivePack: true,
+		Maintenance: MaintenanceOptions{
+			Concurrency:  2,
+			LooseObjects: 3,
+		},
+		EventHandler: func(ev Event) {
+			if ev.Type == MAINTENANCE {
+				events = append(events, ev)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	if out, err := pushTestRepo("./testdata/maintenance/client", server.URL+"/repo"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if _, err := git.ForkRepo("repo", "fork"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit("./testdata/maintenance/client", "-c", "user.name=test", "-c", "user.email=test@example.org", "commit", "--allow-empty", "-m", "fork"); err != nil {
+		t.Fatalf("commit failed: %v\n%s", err, out)
+	}
+	if out, err := runGit("./testdata/maintenance/client", "push", server.URL+"/fork", "master"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	// Unreachable objects of the parent may be used by forks
+	unreachable, err := runGit("./testdata/maintenance/server/repo", "hash-object", "-w", "--stdin")
+	if err != nil {
+		t.Fatalf("hash-object failed: %v\n%s", err, unreachable)
+	}
+	unreachable = strings.TrimSpace(unreachable)
+
+	// Only the parent has enough loose objects
+	g := git.(*gitContext)
+	if err := g.maintainAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Error != nil || filepath.Base(events[0].Dir) != "repo" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	for _, file := range []string{"objects/info/commit-graph", "objects/pack/multi-pack-index"} {
+		if _, err := os.Stat(filepath.Join("./testdata/maintenance/server/repo", file)); err != nil {
+			t.Error(err)
+		}
+	}
+	if bitmaps, _ := filepath.Glob("./testdata/maintenance/server/repo/objects/pack/*.bitmap"); len(bitmaps) != 1 {
+		t.Errorf("got bitmaps %v", bitmaps)
+	}
+	if out, err := runGit("./testdata/maintenance/server/repo", "cat-file", "-e", unreachable); err != nil {
+		t.Errorf("unreachable object was pruned: %v\n%s", err, out)
+	}
+	// Only the unreachable object is left loose
+	if out, _ := runGit("./testdata/maintenance/server/repo", "count-objects"); !strings.HasPrefix(out, "1 objects") {
+		t.Errorf("loose objects left: %s", out)
+	}
+
+	// Forks don't copy the objects of their parents
+	events = nil
+	if err := git.MaintainRepo("fork"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Error != nil {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if out, _ := runGit("./testdata/maintenance/server/fork", "count-objects", "-v"); !strings.Contains(out, "in-pack: 1\n") {
+		t.Errorf("unexpected objects:\n%s", out)
+	}
+	if out, err := runGit("./testdata/maintenance/server/fork", "fsck"); err != nil {
+		t.Errorf("fsck failed: %v\n%s", err, out)
+	}
+
+	// Repositories that are being pushed to are skipped
+	dir, _ := git.RepoDir("fork")
+	g.locks.lockPush(dir)
+	if err := git.MaintainRepo("fork"); err != ErrRepoBusy {
+		t.Errorf("got %v, want ErrRepoBusy", err)
+	}
+	g.locks.unlockPush(dir)
+	if err := git.MaintainRepo("fork"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,110 @@
+package githttp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+// mirrorRemote is the remote of mirror repositories that points to their upstream
+const mirrorRemote = "origin"
+
+// upstream returns the upstream URL of a repository, empty if it isn't a mirror.
+func (g *gitContext) upstream(repoPath string) (string, error) {
+	if g.options.Mirror == nil {
+		return "", nil
+	}
+	return g.options.Mirror(repoPath)
+}
+
+// createMirror clones the upstream of a mirror repository that doesn't exist yet.
+// Concurrent first requests wait for a single clone, it returns false to the ones that didn't create it.
+func (g *gitContext) createMirror(dir string, upstream string) (*gogit.Repository, bool, error) {
+	defer g.lockMirror(dir)()
+
+	if repo, err := gogit.PlainOpen(dir); err == nil {
+		return repo, false, nil
+	}
+	_, err := os.Stat(dir)
+	created := os.IsNotExist(err)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, false, err
+	}
+	repo, err := g.initMirror(dir, upstream)
+	if err != nil {
+		// Leave directories alone that existed before
+		if created {
+			os.RemoveAll(dir)
+			g.removeEmptyParents(dir)
+		}
+		return nil, false, err
+	}
+	return repo, true, nil
+}
+
+func (g *gitContext) initMirror(dir string, upstream string) (*gogit.Repository, error) {
+	repo, err := gogit.PlainInit(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	// Mirrors all refs, i.e. +refs/*:refs/*
+	if _, err := g.gitCommand(dir, "remote", "add", "--mirror=fetch", mirrorRemote, upstream); err != nil {
+		return nil, err
+	}
+	if err := g.fetchMirror(dir); err != nil {
+		return nil, err
+	}
+
+	// HEAD points to the default branch of the upstream
+	out, err := g.gitCommand(dir, "ls-remote", "--symref", mirrorRemote, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "ref:" && fields[2] == "HEAD" {
+			if _, err := g.gitCommand(dir, "symbolic-ref", "HEAD", fields[1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return repo, nil
+}
+
+// updateMirror fetches from the upstream of a mirror repository if its last fetch is older than the MirrorTTL.
+// Concurrent requests wait for a single fetch.
+func (g *gitContext) updateMirror(dir string) error {
+	defer g.lockMirror(dir)()
+
+	fetched, err := os.Stat(filepath.Join(gitDir(dir), "FETCH_HEAD"))
+	if err == nil && time.Since(fetched.ModTime()) < g.options.MirrorTTL {
+		return nil
+	}
+
+	// Maintenance waits for fetches like for pushes
+	g.locks.lockPush(dir)
+	defer g.locks.unlockPush(dir)
+	return g.fetchMirror(dir)
+}
+
+// lockMirror serializes the clone and fetches of a mirror repository.
+// Requests to a mirror that is still being cloned wait in updateMirror. It returns the unlock function.
+func (g *gitContext) lockMirror(dir string) func() {
+	mu, _ := g.mirrorFetches.LoadOrStore(lockKey(dir), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// fetchMirror fetches from the upstream of a mirror repository and fires a MIRROR event.
+func (g *gitContext) fetchMirror(dir string) error {
+	_, err := g.gitCommand(dir, "fetch", "--prune", "--quiet", mirrorRemote)
+	if err != nil {
+		err = &ErrorMirrorFetch{Dir: dir, Err: err}
+	}
+	g.event(Event{Type: MIRROR, Dir: dir, Error: err})
+	return err
+}
@@ -0,0 +1,135 @@
+package githttp
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	defer os.RemoveAll("./testdata/mirror")
+
+	// Upstream repository with a default branch other than master
+	if _, err := initRepo("./testdata/mirror/upstream", true, false); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit("./testdata/mirror/upstream", "symbolic-ref", "HEAD", "refs/heads/main"); err != nil {
+		t.Fatalf("symbolic-ref failed: %v\n%s", err, out)
+	}
+	if out, err := pushTestRepo("./testdata/mirror/client", "../upstream", "master:main"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	upstream, err := filepath.Abs("./testdata/mirror/upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var events []Event
+	options := GitOptions{
+		ProjectRoot: "./testdata/mirror/server",
+		ReceivePack: true,
+		UploadPack:  true,
+		Mirror: func(repo string) (string, error) {
+			if repo == "/mirror" || repo == "/concurrent" {
+				return "file://" + upstream, nil
+			}
+			return "", nil
+		},
+		EventHandler: func(ev Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, ev)
+		},
+	}
+	git, err := NewGitContext(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	// Mirrors are cloned on first access
+	if out, err := runGit("./testdata/mirror", "clone", server.URL+"/mirror", "clone"); err != nil {
+		t.Fatalf("clone failed: %v\n%s", err, out)
+	}
+	if out, _ := runGit("./testdata/mirror/clone", "rev-parse", "--abbrev-ref", "HEAD"); out != "main\n" {
+		t.Errorf("unexpected HEAD %q", out)
+	}
+	if len(events) == 0 || events[0].Type != MIRROR || events[0].Error != nil {
+		t.Errorf("unexpected events %+v", events)
+	}
+	// Other repositories aren't created
+	if out, err := runGit("./testdata/mirror", "ls-remote", server.URL+"/other"); err == nil {
+		t.Errorf("ls-remote of a missing repository succeeded:\n%s", out)
+	}
+
+	// Concurrent first requests share a single clone
+	var wg sync.WaitGroup
+	refs := make([]string, 8)
+	for i := range refs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			refs[i], _ = runGit("./testdata/mirror", "ls-remote", server.URL+"/concurrent", "refs/heads/main")
+		}(i)
+	}
+	wg.Wait()
+	for i, out := range refs {
+		if !strings.HasSuffix(out, "\trefs/heads/main\n") {
+			t.Errorf("request %d got %q", i, out)
+		}
+	}
+
+	// Pushes are rejected
+	out, err := runGit("./testdata/mirror/client", "push", server.URL+"/mirror", "master:main")
+	if err == nil || !strings.Contains(out, "remote error: the repository is a mirror") {
+		t.Errorf("push to mirror: %v\n%s", err, out)
+	}
+
+	// Updates of upstream are fetched
+	if out, err := runGit("./testdata/mirror/client", "-c", "user.name=test", "-c", "user.email=test@example.org", "commit", "--allow-empty", "-m", "update"); err != nil {
+		t.Fatalf("commit failed: %v\n%s", err, out)
+	}
+	if out, err := runGit("./testdata/mirror/client", "push", "../upstream", "master:main", "master:feature"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	head, _ := runGit("./testdata/mirror/client", "rev-parse", "master")
+	if out, _ := runGit("./testdata/mirror", "ls-remote", server.URL+"/mirror", "refs/heads/feature"); !strings.HasPrefix(out, strings.TrimSpace(head)) {
+		t.Errorf("update wasn't fetched: %q", out)
+	}
+
+	// Mirrors are served locally within the TTL
+	options.MirrorTTL = time.Hour
+	cached, err := NewGitContext(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedServer := httptest.NewServer(cached)
+	defer cachedServer.Close()
+	if out, err := runGit("./testdata/mirror/client", "push", "../upstream", ":feature"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if out, _ := runGit("./testdata/mirror", "ls-remote", cachedServer.URL+"/mirror", "refs/heads/feature"); out == "" {
+		t.Error("mirror was fetched within the TTL")
+	}
+
+	// Mirrors are served even if upstream is unavailable
+	events = nil
+	if err := os.Rename(upstream, upstream+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit("./testdata/mirror", "ls-remote", server.URL+"/mirror"); err != nil {
+		t.Errorf("ls-remote failed: %v\n%s", err, out)
+	}
+	if len(events) != 1 || events[0].Type != MIRROR {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if _, ok := events[0].Error.(*ErrorMirrorFetch); !ok {
+		t.Errorf("unexpected error %v", events[0].Error)
+	}
+}
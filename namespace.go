@@ -0,0 +1,63 @@
+package githttp
+
+import (
+	"os"
+	"strings"
+)
+
+// resolveNamespace maps a requested repository to the physical repository that stores it
+// and its git namespace within, which is empty for plain repositories.
+func (g *gitContext) resolveNamespace(repo string) (string, string, error) {
+	if g.options.Namespace == nil {
+		return repo, "", nil
+	}
+	physical, namespace, err := g.options.Namespace(repo)
+	if err != nil {
+		return "", "", err
+	}
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		return repo, "", nil
+	}
+	if strings.Contains(namespace, "..") || strings.Contains(namespace, "//") || strings.ContainsAny(namespace, " ~^:?*[\\") {
+		return "", "", ErrInvalidNamespace
+	}
+	return physical, namespace, nil
+}
+
+// namespacePrefix returns the prefix of the refs of a namespace in the physical repository,
+// e.g. refs/namespaces/a/refs/namespaces/b/ for a/b.
+func namespacePrefix(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	var prefix string
+	for _, component := range strings.Split(namespace, "/") {
+		prefix += "refs/namespaces/" + component + "/"
+	}
+	return prefix
+}
+
+// namespaceEnv returns the environment of git subprocesses that serve a namespace,
+// nil to inherit the environment if there is none.
+func namespaceEnv(namespace string) []string {
+	if namespace == "" {
+		return nil
+	}
+	return append(os.Environ(), "GIT_NAMESPACE="+namespace)
+}
+
+// ensureNamespaceHead points HEAD of a namespace to the branch that HEAD of the physical repository points to,
+// unless it exists already. Clients that clone a namespace check out its HEAD.
+func (g *gitContext) ensureNamespaceHead(dir string, namespace string) error {
+	head := namespacePrefix(namespace) + "HEAD"
+	if _, err := g.gitCommand(dir, "symbolic-ref", "-q", head); err == nil {
+		return nil
+	}
+	target, err := g.gitCommand(dir, "symbolic-ref", "HEAD")
+	if err != nil {
+		return err
+	}
+	_, err = g.gitCommand(dir, "symbolic-ref", head, namespacePrefix(namespace)+strings.TrimSpace(string(target)))
+	return err
+}
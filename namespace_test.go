@@ -0,0 +1,81 @@
+package githttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	defer os.RemoveAll("./testdata/namespace")
+
+	var events []Event
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/namespace/server",
+		AutoCreate:  true,
+		ReceivePack: true,
+		UploadPack:  true,
+		Namespace: func(repo string) (string, string, error) {
+			if strings.HasPrefix(repo, "/customers/") {
+				return "/customers", strings.TrimPrefix(repo, "/customers/"), nil
+			}
+			return repo, "", nil
+		},
+		EventHandler: func(ev Event) {
+			events = append(events, ev)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	if out, err := pushTestRepo("./testdata/namespace/acme", server.URL+"/customers/acme"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if len(events) != 1 || events[0].Repo != "customers/acme" || events[0].Namespace != "acme" || !strings.HasSuffix(events[0].Dir, "customers") {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if _, err := initRepo("./testdata/namespace/globex", false, true); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit("./testdata/namespace/globex", "-c", "user.name=test", "-c", "user.email=test@example.org", "commit", "--allow-empty", "-m", "globex"); err != nil {
+		t.Fatalf("commit failed: %v\n%s", err, out)
+	}
+	if out, err := runGit("./testdata/namespace/globex", "push", server.URL+"/customers/globex", "master", "master:feature"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+
+	// Both are stored in one physical repository
+	out, _ := runGit("./testdata/namespace/server/customers", "for-each-ref", "--format=%(refname)")
+	want := "refs/namespaces/acme/HEAD\nrefs/namespaces/acme/refs/heads/master\n" +
+		"refs/namespaces/globex/HEAD\nrefs/namespaces/globex/refs/heads/feature\nrefs/namespaces/globex/refs/heads/master\n"
+	if out != want {
+		t.Errorf("unexpected refs:\n%s", out)
+	}
+
+	// Each namespace is served as a repository of its own
+	if out, err := runGit("./testdata/namespace", "clone", server.URL+"/customers/acme", "clone"); err != nil {
+		t.Fatalf("clone failed: %v\n%s", err, out)
+	}
+	acme, _ := runGit("./testdata/namespace/acme", "rev-parse", "master")
+	if out, _ := runGit("./testdata/namespace/clone", "rev-parse", "HEAD"); out != acme {
+		t.Errorf("clone is at %q, want %q", out, acme)
+	}
+	if out, _ := runGit("./testdata/namespace", "ls-remote", server.URL+"/customers/acme"); strings.Contains(out, "feature") || !strings.Contains(out, "\tHEAD\n") {
+		t.Errorf("unexpected refs of acme:\n%s", out)
+	}
+
+	// The dumb protocol isn't available
+	res, err := http.Get(server.URL + "/customers/acme/HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 404 {
+		t.Errorf("got %d for HEAD of a namespace", res.StatusCode)
+	}
+}
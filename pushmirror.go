@@ -0,0 +1,291 @@
+package githttp
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+// Default push mirror settings
+const (
+	defaultPushMirrorRetries    = 3
+	defaultPushMirrorRetryDelay = time.Minute
+)
+
+// remoteNameRegex matches valid push mirror names
+var remoteNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// SecretStore provides the credentials for pushing to push mirrors.
+type SecretStore interface {
+	// Credentials returns the username and password for a push mirror of a repository,
+	// both empty if the mirror doesn't need any.
+	Credentials(repo string, mirror string, url string) (username string, password string, err error)
+}
+
+// PushMirror is a downstream remote that the refs of a repository are replicated to after each push.
+type PushMirror struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	PushMirrorStatus
+}
+
+// PushMirrorStatus is the replication status of a push mirror since the server started.
+type PushMirrorStatus struct {
+	// Replication is queued, running or waiting for a retry
+	Pending bool `json:"pending"`
+	// Number of failed attempts since the last successful one
+	Failures    int        `json:"failures"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+// pushMirrorJob replicates a repository to one push mirror, pushes while it runs are coalesced.
+type pushMirrorJob struct {
+	status PushMirrorStatus
+	// Another replication is due after the running one
+	queued  bool
+	running bool
+}
+
+// pushMirrorQueue holds the replication jobs of all push mirrors.
+type pushMirrorQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*pushMirrorJob
+}
+
+// AddPushMirror adds a push mirror to a repository.
+// It's a git remote with remote.<name>.mirror set, like git remote add --mirror=push creates it.
+func (g *gitContext) AddPushMirror(repoPath string, name string, url string) error {
+	dir, err := g.pushMirrorDir(repoPath, name)
+	if err != nil {
+		return err
+	}
+	if _, err := g.gitCommand(dir, "config", "--get", "remote."+name+".url"); err == nil {
+		return ErrMirrorExists
+	}
+	_, err = g.gitCommand(dir, "remote", "add", "--mirror=push", name, url)
+	return err
+}
+
+// RemovePushMirror removes a push mirror from a repository.
+func (g *gitContext) RemovePushMirror(repoPath string, name string) error {
+	dir, err := g.pushMirrorDir(repoPath, name)
+	if err != nil {
+		return err
+	}
+	if !g.isPushMirror(dir, name) {
+		return ErrMirrorNotFound
+	}
+	if _, err := g.gitCommand(dir, "remote", "remove", name); err != nil {
+		return err
+	}
+
+	g.pushMirrors.mu.Lock()
+	defer g.pushMirrors.mu.Unlock()
+	if job, ok := g.pushMirrors.jobs[pushMirrorKey(dir, name)]; ok && !job.running {
+		delete(g.pushMirrors.jobs, pushMirrorKey(dir, name))
+	}
+	return nil
+}
+
+// PushMirrors returns the push mirrors of a repository and their status.
+func (g *gitContext) PushMirrors(repoPath string) ([]PushMirror, error) {
+	dir, err := g.managedDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if !isRepoDir(dir) {
+		return nil, gogit.ErrRepositoryNotExists
+	}
+	names, err := g.pushMirrorNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mirrors := []PushMirror{}
+	for _, name := range names {
+		url, err := g.getGitConfig("remote."+name+".url", dir)
+		if err != nil {
+			return nil, err
+		}
+		mirror := PushMirror{Name: name, URL: url}
+		g.pushMirrors.mu.Lock()
+		if job, ok := g.pushMirrors.jobs[pushMirrorKey(dir, name)]; ok {
+			mirror.PushMirrorStatus = job.status
+		}
+		g.pushMirrors.mu.Unlock()
+		mirrors = append(mirrors, mirror)
+	}
+	return mirrors, nil
+}
+
+func (g *gitContext) pushMirrorDir(repoPath string, name string) (string, error) {
+	if !remoteNameRegex.MatchString(name) {
+		return "", ErrInvalidMirrorName
+	}
+	dir, err := g.managedDir(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if !isRepoDir(dir) {
+		return "", gogit.ErrRepositoryNotExists
+	}
+	return dir, nil
+}
+
+// pushMirrorNames returns the names of the push mirrors of a repository.
+// Remotes of pull-through mirrors are mirrors too, but they have fetch refspecs.
+func (g *gitContext) pushMirrorNames(dir string) ([]string, error) {
+	out, err := g.gitCommand(dir, "config", "--get-regexp", `^remote\..*\.mirror$`)
+	if exitCode(err) == 1 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != "true" {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(fields[0], "remote."), ".mirror")
+		if g.isPushMirror(dir, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (g *gitContext) isPushMirror(dir string, name string) bool {
+	mirror, err := g.gitCommand(dir, "config", "--bool", "remote."+name+".mirror")
+	if err != nil || strings.TrimSpace(string(mirror)) != "true" {
+		return false
+	}
+	_, err = g.gitCommand(dir, "config", "--get", "remote."+name+".fetch")
+	return exitCode(err) == 1
+}
+
+// schedulePushMirrors queues the replication of a repository to all its push mirrors.
+func (g *gitContext) schedulePushMirrors(dir string) {
+	// The directories of requests may be relative
+	dir = lockKey(dir)
+	names, err := g.pushMirrorNames(dir)
+	if err != nil {
+		g.event(Event{Type: PUSH_MIRROR, Dir: dir, Error: err})
+		return
+	}
+
+	g.pushMirrors.mu.Lock()
+	defer g.pushMirrors.mu.Unlock()
+	if g.pushMirrors.jobs == nil {
+		g.pushMirrors.jobs = map[string]*pushMirrorJob{}
+	}
+	for _, name := range names {
+		key := pushMirrorKey(dir, name)
+		job, ok := g.pushMirrors.jobs[key]
+		if !ok {
+			job = &pushMirrorJob{}
+			g.pushMirrors.jobs[key] = job
+		}
+		job.queued = true
+		job.status.Pending = true
+		if !job.running {
+			job.running = true
+			go g.runPushMirror(dir, name, job)
+		}
+	}
+}
+
+// runPushMirror replicates a repository to a push mirror until no replication is queued anymore.
+// Failed pushes are retried with an exponential backoff.
+func (g *gitContext) runPushMirror(dir string, name string, job *pushMirrorJob) {
+	retries := g.options.PushMirrorRetries
+	if retries == 0 {
+		retries = defaultPushMirrorRetries
+	}
+	delay := g.options.PushMirrorRetryDelay
+	if delay <= 0 {
+		delay = defaultPushMirrorRetryDelay
+	}
+
+	queue := &g.pushMirrors
+	for {
+		queue.mu.Lock()
+		if !job.queued {
+			job.running = false
+			job.status.Pending = false
+			queue.mu.Unlock()
+			return
+		}
+		job.queued = false
+		queue.mu.Unlock()
+
+		for attempt, wait := 0, delay; ; attempt, wait = attempt+1, wait*2 {
+			err := g.pushToMirror(dir, name)
+			now := time.Now()
+
+			queue.mu.Lock()
+			job.status.LastAttempt = &now
+			if err == nil {
+				job.status.LastSuccess = &now
+				job.status.LastError = ""
+				job.status.Failures = 0
+			} else {
+				job.status.LastError = err.Error()
+				job.status.Failures++
+			}
+			queue.mu.Unlock()
+
+			g.event(Event{Type: PUSH_MIRROR, Dir: dir, Remote: name, Error: err})
+			if err == nil || attempt >= retries {
+				break
+			}
+			time.Sleep(wait)
+		}
+	}
+}
+
+// pushToMirror pushes all refs of a repository to a push mirror.
+// The credentials are passed to git by a credential helper that reads them from the environment.
+func (g *gitContext) pushToMirror(dir string, name string) error {
+	url, err := g.getGitConfig("remote."+name+".url", dir)
+	if err != nil {
+		return &ErrorPushMirror{Dir: dir, Remote: name, Err: err}
+	}
+	var args []string
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if g.options.Secrets != nil {
+		repo, err := g.link(dir)
+		if err != nil {
+			return &ErrorPushMirror{Dir: dir, Remote: name, Err: err}
+		}
+		username, password, err := g.options.Secrets.Credentials(repo, name, url)
+		if err != nil {
+			return &ErrorPushMirror{Dir: dir, Remote: name, Err: err}
+		}
+		if username != "" || password != "" {
+			args = append(args, "-c", "credential.helper=",
+				"-c", `credential.helper=!f() { test "$1" = get && echo "username=$GITHTTP_USERNAME" && echo "password=$GITHTTP_PASSWORD"; }; f`)
+			env = append(env, "GITHTTP_USERNAME="+username, "GITHTTP_PASSWORD="+password)
+		}
+	}
+
+	cmd := exec.Command(g.options.GitBinPath, append(args, "push", "--mirror", "--quiet", name)...)
+	cmd.Dir = dir
+	cmd.Env = env
+	if _, err := cmd.Output(); err != nil {
+		return &ErrorPushMirror{Dir: dir, Remote: name, Err: err}
+	}
+	return nil
+}
+
+func pushMirrorKey(dir string, name string) string {
+	return lockKey(dir) + "\x00" + name
+}
@@ -0,0 +1,130 @@
+package githttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testSecrets map[string]string
+
+func (s testSecrets) Credentials(repo string, mirror string, url string) (string, string, error) {
+	return s[repo+" "+mirror], "secret", nil
+}
+
+func TestPushMirror(t *testing.T) {
+	defer os.RemoveAll("./testdata/pushmirror")
+
+	// Downstream server that requires credentials
+	downstream, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/pushmirror/downstream",
+		AutoCreate:  true,
+		ReceivePack: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	downstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "backup" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		downstream.ServeHTTP(w, r)
+	}))
+	defer downstreamServer.Close()
+
+	events := make(chan Event, 10)
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot:          "./testdata/pushmirror/server",
+		AutoCreate:           true,
+		ReceivePack:          true,
+		Secrets:              testSecrets{"repo backup": "backup"},
+		PushMirrorRetries:    1,
+		PushMirrorRetryDelay: 10 * time.Millisecond,
+		EventHandler: func(ev Event) {
+			if ev.Type == PUSH_MIRROR {
+				events <- ev
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	if _, err := git.CreateRepo("repo", CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.AddPushMirror("repo", "backup", downstreamServer.URL+"/backup"); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := filepath.Abs("./testdata/pushmirror/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := git.AddPushMirror("repo", "broken", "file://"+missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.AddPushMirror("repo", "broken", "file://"+missing); err != ErrMirrorExists {
+		t.Errorf("got %v, want ErrMirrorExists", err)
+	}
+	if err := git.AddPushMirror("repo", "-x", "file://"+missing); err != ErrInvalidMirrorName {
+		t.Errorf("got %v, want ErrInvalidMirrorName", err)
+	}
+
+	if out, err := pushTestRepo("./testdata/pushmirror/client", server.URL+"/repo"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+
+	// One event for the backup, two attempts for the broken mirror
+	var backupErr error
+	brokenErrs := 0
+	for i := 0; i < 3; i++ {
+		select {
+		case ev := <-events:
+			if ev.Remote == "backup" {
+				backupErr = ev.Error
+			} else if _, ok := ev.Error.(*ErrorPushMirror); ok {
+				brokenErrs++
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for push mirror events")
+		}
+	}
+	if backupErr != nil || brokenErrs != 2 {
+		t.Errorf("unexpected events: backup %v, %d broken", backupErr, brokenErrs)
+	}
+	master, _ := runGit("./testdata/pushmirror/client", "rev-parse", "master")
+	if out, _ := runGit("./testdata/pushmirror/downstream/backup", "rev-parse", "master"); out != master {
+		t.Errorf("downstream master is %q, want %q", out, master)
+	}
+
+	// The status is reported once the queue is idle
+	var mirrors []PushMirror
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if mirrors, err = git.PushMirrors("repo"); err != nil {
+			t.Fatal(err)
+		}
+		if !mirrors[0].Pending && !mirrors[1].Pending {
+			break
+		}
+	}
+	if len(mirrors) != 2 || mirrors[0].Name != "backup" || mirrors[0].LastSuccess == nil || mirrors[0].Failures != 0 {
+		t.Errorf("unexpected status %+v", mirrors)
+	}
+	if mirrors[1].Name != "broken" || mirrors[1].LastSuccess != nil || mirrors[1].Failures != 2 || mirrors[1].LastError == "" {
+		t.Errorf("unexpected status %+v", mirrors[1])
+	}
+
+	if err := git.RemovePushMirror("repo", "broken"); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.RemovePushMirror("repo", "broken"); err != ErrMirrorNotFound {
+		t.Errorf("got %v, want ErrMirrorNotFound", err)
+	}
+}
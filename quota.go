@@ -0,0 +1,129 @@
+package githttp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Git config keys of the per repository limits, in bytes with optional k, m or g suffix
+const (
+	maxPushSizeKey = configSection + ".maxPushSize"
+	maxRepoSizeKey = configSection + ".maxRepoSize"
+)
+
+// pushQuota limits the size of a push
+type pushQuota struct {
+	// Number of bytes the push may have, exceeded from the start if not positive
+	size int64
+	// Error if the push exceeds the size
+	err *ErrorQuotaExceeded
+}
+
+// pushQuota returns the quota of a push to a repository, nil if it is unlimited.
+// The per repository limits take precedence over the global ones.
+func (g *gitContext) pushQuota(dir string) (*pushQuota, error) {
+	maxPush, err := g.sizeSetting(dir, maxPushSizeKey, g.options.MaxPushSize)
+	if err != nil {
+		return nil, err
+	}
+	maxRepo, err := g.sizeSetting(dir, maxRepoSizeKey, g.options.MaxRepoSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var quota *pushQuota
+	if maxPush > 0 {
+		quota = &pushQuota{maxPush, &ErrorQuotaExceeded{Limit: "push size", Max: maxPush}}
+	}
+	if maxRepo > 0 {
+		size, err := repoSize(dir)
+		if err != nil {
+			return nil, err
+		}
+		if remaining := maxRepo - size; quota == nil || remaining < quota.size {
+			quota = &pushQuota{remaining, &ErrorQuotaExceeded{Limit: "repository size", Max: maxRepo}}
+		}
+	}
+	return quota, nil
+}
+
+// sizeSetting reads a size from the git config of a repository, or returns the default if it isn't set.
+func (g *gitContext) sizeSetting(dir string, key string, def int64) (int64, error) {
+	out, err := g.gitCommand(dir, "config", "--int", key)
+	if exitCode(err) == 1 {
+		return def, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// repoSize returns the number of bytes that the files of a repository occupy.
+func repoSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(gitDir(dir), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Temporary files may disappear in the meantime
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// writeQuotaReport rejects all commands of a push that exceeded its quota.
+// The rest of the request body is discarded.
+func writeQuotaReport(w http.ResponseWriter, req *receivePackRequest, body io.Reader, denied []receivePackCommand, quotaErr *ErrorQuotaExceeded) error {
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return err
+	}
+
+	var status []byte
+	for _, c := range req.commands {
+		reason := quotaErr.Error()
+		for _, d := range denied {
+			if d.update.Ref == c.update.Ref {
+				reason = "denied"
+			}
+		}
+		status = append(status, packetWrite("ng "+c.update.Ref+" "+reason+"\n")...)
+	}
+	return writeReport(w, req, "unpack "+quotaErr.Error()+"\n", status)
+}
+
+// quotaEvents fires the events of a push that exceeded its quota.
+func (g *gitContext) quotaEvents(hr HandlerReq, req *receivePackRequest, quotaErr *ErrorQuotaExceeded) {
+	for _, c := range req.commands {
+		for _, e := range scanPush(c.line) {
+			e.Error = quotaErr
+			g.requestEvent(hr, e)
+		}
+	}
+}
+
+// formatSize formats a number of bytes for humans.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,86 @@
+package githttp
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	defer os.RemoveAll("./testdata/quota")
+
+	var events []Event
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/quota/server",
+		AutoCreate:  true,
+		ReceivePack: true,
+		MaxPushSize: 64 * 1024,
+		EventHandler: func(ev Event) {
+			events = append(events, ev)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	if out, err := pushTestRepo("./testdata/quota/client", server.URL+"/repo"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+
+	// Commit a file that doesn't compress
+	data := make([]byte, 256*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("./testdata/quota/client/large.bin", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit("./testdata/quota/client", "add", "large.bin"); err != nil {
+		t.Fatalf("add failed: %v\n%s", err, out)
+	}
+	if out, err := runGit("./testdata/quota/client", "-c", "user.name=test", "-c", "user.email=test@example.org", "commit", "-m", "large"); err != nil {
+		t.Fatalf("commit failed: %v\n%s", err, out)
+	}
+	before, _ := runGit("./testdata/quota/server/repo", "count-objects")
+
+	// The global push size limit applies
+	events = nil
+	out, err := runGit("./testdata/quota/client", "push", server.URL+"/repo", "master")
+	if err == nil || !strings.Contains(out, "push size limit of 64.0 KiB exceeded") {
+		t.Errorf("push exceeding the push size limit: %v\n%s", err, out)
+	}
+	if len(events) != 1 || events[0].Type != PUSH {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if quotaErr, ok := events[0].Error.(*ErrorQuotaExceeded); !ok || quotaErr.Limit != "push size" {
+		t.Errorf("unexpected error %v", events[0].Error)
+	}
+	if after, _ := runGit("./testdata/quota/server/repo", "count-objects"); after != before {
+		t.Errorf("objects of the rejected push were kept: %q, before %q", after, before)
+	}
+
+	// The per repository limits take precedence
+	if out, err := runGit("./testdata/quota/server/repo", "config", "githttp.maxPushSize", "1m"); err != nil {
+		t.Fatalf("config failed: %v\n%s", err, out)
+	}
+	if out, err := runGit("./testdata/quota/server/repo", "config", "githttp.maxRepoSize", "1m"); err != nil {
+		t.Fatalf("config failed: %v\n%s", err, out)
+	}
+	if out, err := runGit("./testdata/quota/client", "push", server.URL+"/repo", "master"); err != nil {
+		t.Errorf("push failed: %v\n%s", err, out)
+	}
+
+	// Pushes that would fill the repository beyond its size limit are rejected
+	if out, err := runGit("./testdata/quota/server/repo", "config", "githttp.maxRepoSize", "200k"); err != nil {
+		t.Fatalf("config failed: %v\n%s", err, out)
+	}
+	out, err = runGit("./testdata/quota/client", "push", server.URL+"/repo", "master:other")
+	if err == nil || !strings.Contains(out, "repository size limit of 200.0 KiB exceeded") {
+		t.Errorf("push to a full repository: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,216 @@
+package githttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+// metaDir is the directory below the project root where githttp keeps its own data, it's no valid repository path
+const metaDir = ".githttp"
+
+// maxRedirects is the number of redirects that are followed to resolve a chain
+const maxRedirects = 10
+
+// redirectTable maps old repository paths to new ones, persisted in redirects.json of the metaDir.
+type redirectTable struct {
+	mu sync.Mutex
+	// Loaded on first use, nil before
+	paths map[string]string
+}
+
+// Redirects returns the redirects from old to new repository paths.
+func (g *gitContext) Redirects() (map[string]string, error) {
+	g.redirects.mu.Lock()
+	defer g.redirects.mu.Unlock()
+	return g.loadRedirects()
+}
+
+// SetRedirect permanently redirects requests for a repository path that doesn't exist to an existing repository.
+func (g *gitContext) SetRedirect(from string, to string) error {
+	from, to = strings.Trim(from, "/"), strings.Trim(to, "/")
+	fromDir, err := g.managedDir(from)
+	if err != nil {
+		return err
+	}
+	if isRepoDir(fromDir) {
+		return ErrRepoExists
+	}
+	toDir, err := g.managedDir(to)
+	if err != nil {
+		return err
+	}
+	if !isRepoDir(toDir) {
+		return gogit.ErrRepositoryNotExists
+	}
+
+	g.redirects.mu.Lock()
+	defer g.redirects.mu.Unlock()
+	paths, err := g.loadRedirects()
+	if err != nil {
+		return err
+	}
+	// The target or one it redirects to must not redirect back
+	for next, hops := to, 0; hops <= maxRedirects; hops++ {
+		if next == from {
+			return ErrRedirectLoop
+		}
+		var ok bool
+		if next, ok = paths[next]; !ok {
+			break
+		}
+	}
+	paths[from] = to
+	return g.saveRedirects(paths)
+}
+
+// RemoveRedirect removes the redirect of a repository path.
+func (g *gitContext) RemoveRedirect(from string) error {
+	from = strings.Trim(from, "/")
+	g.redirects.mu.Lock()
+	defer g.redirects.mu.Unlock()
+	paths, err := g.loadRedirects()
+	if err != nil {
+		return err
+	}
+	if _, ok := paths[from]; !ok {
+		return ErrRedirectNotFound
+	}
+	delete(paths, from)
+	return g.saveRedirects(paths)
+}
+
+// redirectMoved records the move of a repository. Redirects to its old path follow it,
+// the redirect of its new path is obsolete.
+func (g *gitContext) redirectMoved(from string, to string) error {
+	from, to = strings.Trim(from, "/"), strings.Trim(to, "/")
+	g.redirects.mu.Lock()
+	defer g.redirects.mu.Unlock()
+	paths, err := g.loadRedirects()
+	if err != nil {
+		return err
+	}
+	for old, target := range paths {
+		if target == from {
+			paths[old] = to
+		}
+	}
+	delete(paths, to)
+	paths[from] = to
+	return g.saveRedirects(paths)
+}
+
+// dropRedirect removes the redirect of a repository path that is used by a repository again.
+func (g *gitContext) dropRedirect(repoPath string) error {
+	repoPath = strings.Trim(repoPath, "/")
+	g.redirects.mu.Lock()
+	defer g.redirects.mu.Unlock()
+	paths, err := g.loadRedirects()
+	if err != nil {
+		return err
+	}
+	if _, ok := paths[repoPath]; !ok {
+		return nil
+	}
+	delete(paths, repoPath)
+	return g.saveRedirects(paths)
+}
+
+// redirectTarget returns the path that requests for a repository are redirected to, if any.
+func (g *gitContext) redirectTarget(repoPath string) (string, bool, error) {
+	g.redirects.mu.Lock()
+	defer g.redirects.mu.Unlock()
+	paths, err := g.loadRedirects()
+	if err != nil {
+		return "", false, err
+	}
+	target, ok := paths[strings.Trim(repoPath, "/")]
+	if !ok {
+		return "", false, nil
+	}
+	for hops := 1; hops < maxRedirects; hops++ {
+		next, ok := paths[target]
+		if !ok {
+			break
+		}
+		target = next
+	}
+	return target, true, nil
+}
+
+// redirect responds with a permanent redirect to the same resource of another repository.
+// Methods other than GET and HEAD are redirected with 308 to keep the request body.
+func redirect(w http.ResponseWriter, r *http.Request, repo string, target string) {
+	u := *r.URL
+	u.Path = "/" + target + strings.TrimPrefix(r.URL.Path, repo)
+	code := http.StatusMovedPermanently
+	if r.Method != "GET" && r.Method != "HEAD" {
+		code = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, u.String(), code)
+}
+
+func (g *gitContext) redirectsFile() (string, error) {
+	root, err := g.root()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, metaDir, "redirects.json"), nil
+}
+
+// loadRedirects returns a copy of the redirect table, the caller must hold its lock.
+func (g *gitContext) loadRedirects() (map[string]string, error) {
+	if g.redirects.paths == nil {
+		file, err := g.redirectsFile()
+		if err != nil {
+			return nil, err
+		}
+		loaded := map[string]string{}
+		data, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &loaded); err != nil {
+				return nil, err
+			}
+		}
+		g.redirects.paths = loaded
+	}
+
+	paths := make(map[string]string, len(g.redirects.paths))
+	for from, to := range g.redirects.paths {
+		paths[from] = to
+	}
+	return paths, nil
+}
+
+// saveRedirects persists the redirect table, the caller must hold its lock.
+// The file is replaced atomically.
+func (g *gitContext) saveRedirects(paths map[string]string) error {
+	file, err := g.redirectsFile()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(paths, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file+".tmp", data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return err
+	}
+	g.redirects.paths = paths
+	return nil
+}
@@ -0,0 +1,113 @@
+package githttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	defer os.RemoveAll("./testdata/redirect")
+
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/redirect/server",
+		AutoCreate:  true,
+		ReceivePack: true,
+		UploadPack:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	if out, err := pushTestRepo("./testdata/redirect/client", server.URL+"/team/old"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if err := git.MoveRepo("team/old", "team/new"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clients follow the redirect of the old path
+	if out, err := runGit("./testdata/redirect", "clone", server.URL+"/team/old", "clone"); err != nil {
+		t.Fatalf("clone failed: %v\n%s", err, out)
+	}
+	if _, err := os.Stat("./testdata/redirect/clone/" + testFile); err != nil {
+		t.Error(err)
+	}
+	if out, err := runGit("./testdata/redirect/client", "push", server.URL+"/team/old", "master:other"); err != nil {
+		t.Errorf("push failed: %v\n%s", err, out)
+	}
+	if out, _ := runGit("./testdata/redirect/server/team/new", "rev-parse", "--verify", "other"); out == "" {
+		t.Error("push wasn't redirected")
+	}
+	if _, err := os.Stat("./testdata/redirect/server/team/old"); !os.IsNotExist(err) {
+		t.Errorf("old path was created again: %v", err)
+	}
+
+	// Requests with a body are redirected with 308
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Post(server.URL+"/team/old/git-upload-pack?x=1", "application/x-git-upload-pack-request", strings.NewReader("0000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 308 || res.Header.Get("Location") != "/team/new/git-upload-pack?x=1" {
+		t.Errorf("got %d to %q", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	// Redirects follow further moves
+	if err := git.MoveRepo("team/new", "team/newer"); err != nil {
+		t.Fatal(err)
+	}
+	redirects, err := git.Redirects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"team/old": "team/newer", "team/new": "team/newer"}; !reflect.DeepEqual(redirects, want) {
+		t.Errorf("got %v, want %v", redirects, want)
+	}
+
+	// The table is persisted
+	reloaded, err := NewGitContext(GitOptions{ProjectRoot: "./testdata/redirect/server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirects, _ := reloaded.Redirects(); len(redirects) != 2 {
+		t.Errorf("unexpected persisted redirects %v", redirects)
+	}
+
+	// Paths of existing repositories can't be redirected, new repositories replace redirects
+	if err := git.SetRedirect("team/newer", "team/old"); err != ErrRepoExists {
+		t.Errorf("got %v, want ErrRepoExists", err)
+	}
+	if _, err := git.CreateRepo("team/new", CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if redirects, _ := git.Redirects(); len(redirects) != 1 {
+		t.Errorf("unexpected redirects %v", redirects)
+	}
+	if err := git.RemoveRedirect("team/new"); err != ErrRedirectNotFound {
+		t.Errorf("got %v, want ErrRedirectNotFound", err)
+	}
+
+	// Loops are rejected even if the table contains stale redirects
+	g := git.(*gitContext)
+	g.redirects.paths = map[string]string{"team/newer": "a", "a": "b"}
+	if err := git.SetRedirect("b", "team/newer"); err != ErrRedirectLoop {
+		t.Errorf("got %v, want ErrRedirectLoop", err)
+	}
+	if err := git.SetRedirect("c", "team/newer"); err != nil {
+		t.Error(err)
+	}
+
+	// The metadata directory isn't a repository
+	if _, err := git.CreateRepo(".githttp", CreateOptions{}); err != ErrInvalidRepoPath {
+		t.Errorf("got %v, want ErrInvalidRepoPath", err)
+	}
+}
@@ -0,0 +1,294 @@
+package githttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ZeroID is the object id git uses for refs that are created or deleted.
+const ZeroID = "0000000000000000000000000000000000000000"
+
+// RefUpdate is a single ref update command of a push.
+type RefUpdate struct {
+	// Public path to the repository
+	Repo string
+	// Full name of the ref, e.g. refs/heads/master
+	Ref string
+	// Previous and new object id, ZeroID for creations and deletions
+	Old string
+	New string
+}
+
+// IsCreate returns true if the update creates the ref.
+func (u RefUpdate) IsCreate() bool {
+	return u.Old == ZeroID
+}
+
+// IsDelete returns true if the update deletes the ref.
+func (u RefUpdate) IsDelete() bool {
+	return u.New == ZeroID
+}
+
+// receivePackCommand is a parsed command pkt-line of a receive-pack request.
+type receivePackCommand struct {
+	update RefUpdate
+	// pkt-line payload without capabilities
+	line string
+}
+
+// receivePackRequest is the command section of a receive-pack request.
+type receivePackRequest struct {
+	shallow      []string
+	commands     []receivePackCommand
+	capabilities string
+	raw          []byte
+}
+
+// readReceivePackRequest reads the command section of a receive-pack request from r.
+// The pack data remains unread.
+func readReceivePackRequest(r io.Reader, repo string) (*receivePackRequest, error) {
+	lines, raw, err := packetReadSection(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &receivePackRequest{raw: raw}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "shallow ") {
+			req.shallow = append(req.shallow, line)
+			continue
+		}
+		if i := strings.IndexByte(line, 0); i >= 0 {
+			req.capabilities = strings.TrimSuffix(line[i+1:], "\n")
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		req.commands = append(req.commands, receivePackCommand{
+			update: RefUpdate{
+				Repo: repo,
+				Old:  fields[0],
+				New:  fields[1],
+				Ref:  fields[2],
+			},
+			line: line,
+		})
+	}
+	return req, nil
+}
+
+// hasCapability returns true if the client requested the capability.
+func (req *receivePackRequest) hasCapability(name string) bool {
+	for _, c := range strings.Fields(req.capabilities) {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// encode returns the command section with the given commands only.
+func (req *receivePackRequest) encode(commands []receivePackCommand) []byte {
+	var buf bytes.Buffer
+	for _, line := range req.shallow {
+		buf.Write(packetWrite(line))
+	}
+	for i, c := range commands {
+		line := c.line
+		if i == 0 {
+			line += "\x00" + req.capabilities
+		}
+		buf.Write(packetWrite(line))
+	}
+	buf.Write(packetFlush())
+	return buf.Bytes()
+}
+
+// sideband returns true if the client requested its report on side-band channels.
+func (req *receivePackRequest) sideband() bool {
+	return req.hasCapability("side-band-64k") || req.hasCapability("side-band")
+}
+
+// reportStatus returns true if the client requested a status report.
+func (req *receivePackRequest) reportStatus() bool {
+	return req.hasCapability("report-status") || req.hasCapability("report-status-v2")
+}
+
+// authorizeRefs applies the RefAuthorizer to the commands of a push.
+// It returns the request body for git with the denied commands removed
+// as well as the denied commands, or a nil body if all commands were denied.
+// Atomic pushes are denied as a whole if any of their commands is.
+func (g *gitContext) authorizeRefs(hr HandlerReq, body io.Reader) (*receivePackRequest, io.Reader, []receivePackCommand, error) {
+	req, err := readReceivePackRequest(body, hr.Repo)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	identity := RequestIdentity(hr.r)
+	var allowed, denied []receivePackCommand
+	for _, c := range req.commands {
+		ok, err := g.options.RefAuthorizer(identity, c.update)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if ok {
+			allowed = append(allowed, c)
+		} else {
+			denied = append(denied, c)
+		}
+	}
+
+	switch {
+	case len(denied) == 0:
+		return req, io.MultiReader(bytes.NewReader(req.raw), body), nil, nil
+	case len(allowed) == 0 || req.hasCapability("atomic"):
+		return req, nil, req.commands, nil
+	}
+	return req, io.MultiReader(bytes.NewReader(req.encode(allowed)), body), denied, nil
+}
+
+// deniedEvents returns the events of the denied commands.
+func deniedEvents(denied []receivePackCommand) []Event {
+	var events []Event
+	for _, c := range denied {
+		for _, e := range scanPush(c.line) {
+			e.Error = &ErrorRefDenied{c.update.Ref}
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// refStatus encodes the report-status lines for denied commands.
+func refStatus(denied []receivePackCommand) []byte {
+	var buf bytes.Buffer
+	for _, c := range denied {
+		buf.Write(packetWrite("ng " + c.update.Ref + " denied\n"))
+	}
+	return buf.Bytes()
+}
+
+// writeDeniedReport responds to a push of which all commands were denied
+// without running git. The pack data is discarded.
+func writeDeniedReport(w http.ResponseWriter, req *receivePackRequest, body io.Reader, denied []receivePackCommand) error {
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return err
+	}
+
+	return writeReport(w, req, "unpack ok\n", refStatus(denied))
+}
+
+// writeReport writes a report-status response with the given unpack and ref status,
+// as requested by the client.
+func writeReport(w http.ResponseWriter, req *receivePackRequest, unpack string, status []byte) error {
+	var report []byte
+	if req.reportStatus() {
+		report = append(packetWrite(unpack), status...)
+		report = append(report, packetFlush()...)
+	}
+	if req.sideband() {
+		if len(report) > 0 {
+			report = sidebandWrite(1, report)
+		}
+		report = append(report, packetFlush()...)
+	}
+	_, err := w.Write(report)
+	return err
+}
+
+// injectRefStatus copies the receive-pack output of git from src to dst
+// and inserts the status of the denied commands right after the unpack status.
+func injectRefStatus(dst io.Writer, src io.Reader, req *receivePackRequest, denied []receivePackCommand) error {
+	if !req.reportStatus() || len(denied) == 0 {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	status := refStatus(denied)
+
+	if !req.sideband() {
+		// The first pkt-line is the unpack status
+		payload, raw, err := packetRead(src)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(raw); err != nil {
+			return err
+		}
+		if payload != nil {
+			if _, err := dst.Write(status); err != nil {
+				return err
+			}
+		}
+		_, err = io.Copy(dst, src)
+		return err
+	}
+
+	// The report is multiplexed into band 1 and may be split
+	// across several side-band packets, find the end of the unpack status
+	var report []byte
+	for {
+		payload, raw, err := packetRead(src)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Flush-pkts are nil, empty pkt-lines have no band
+		if len(payload) == 0 || payload[0] != 1 {
+			if _, err := dst.Write(raw); err != nil {
+				return err
+			}
+			if payload == nil {
+				break
+			}
+			continue
+		}
+
+		data := payload[1:]
+		seen := len(report)
+		report = append(report, data...)
+		if len(report) < pktLenSize {
+			if _, err := dst.Write(raw); err != nil {
+				return err
+			}
+			continue
+		}
+		unpackLen, err := parsePktLen(report[:pktLenSize])
+		if err != nil {
+			return err
+		}
+		if unpackLen == 0 || len(report) < unpackLen {
+			if _, err := dst.Write(raw); err != nil {
+				return err
+			}
+			if unpackLen == 0 {
+				break
+			}
+			continue
+		}
+
+		split := unpackLen - seen
+		parts := [][]byte{sidebandWrite(1, data[:split]), sidebandWrite(1, status)}
+		if split < len(data) {
+			parts = append(parts, sidebandWrite(1, data[split:]))
+		}
+		for _, part := range parts {
+			if _, err := dst.Write(part); err != nil {
+				return err
+			}
+		}
+		break
+	}
+
+	_, err := io.Copy(dst, src)
+	return err
+}
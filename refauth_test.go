@@ -0,0 +1,118 @@
+package githttp
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRefAuthorizer(t *testing.T) {
+	defer os.RemoveAll("./testdata/refauth")
+
+	var events []Event
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/refauth/server",
+		AutoCreate:  true,
+		ReceivePack: true,
+		UploadPack:  true,
+		EventHandler: func(ev Event) {
+			events = append(events, ev)
+		},
+		RefAuthorizer: func(identity *Identity, update RefUpdate) (bool, error) {
+			return !strings.HasPrefix(update.Ref, "refs/heads/release"), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	// Partially denied push
+	out, err := pushTestRepo("./testdata/refauth/client", server.URL+"/repo", "master", "master:release")
+	if err == nil {
+		t.Errorf("push should have failed:\n%s", out)
+	}
+	if !strings.Contains(out, "[remote rejected] master -> release (denied)") {
+		t.Errorf("missing rejection in output:\n%s", out)
+	}
+	if !strings.Contains(out, "[new branch]      master -> master") {
+		t.Errorf("master should have been pushed:\n%s", out)
+	}
+	refs, _ := runGit("./testdata/refauth/server/repo", "for-each-ref")
+	if !strings.Contains(refs, "refs/heads/master") || strings.Contains(refs, "refs/heads/release") {
+		t.Errorf("unexpected refs on server:\n%s", refs)
+	}
+
+	// Fully denied push
+	out, err = runGit("./testdata/refauth/client", "push", server.URL+"/repo", "master:release/1.0")
+	if err == nil || !strings.Contains(out, "[remote rejected] master -> release/1.0 (denied)") {
+		t.Errorf("push should have been rejected: %v\n%s", err, out)
+	}
+
+	// Atomic pushes are denied as a whole
+	out, err = runGit("./testdata/refauth/client", "push", "--atomic", server.URL+"/repo", "master:dev", "master:release/2.0")
+	if err == nil || !strings.Contains(out, "[remote rejected] master -> release/2.0 (denied)") {
+		t.Errorf("push should have been rejected: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "[remote rejected] master -> dev") {
+		t.Errorf("dev should have been rejected:\n%s", out)
+	}
+	refs, _ = runGit("./testdata/refauth/server/repo", "for-each-ref")
+	if strings.Contains(refs, "refs/heads/dev") {
+		t.Errorf("atomic push was applied partially:\n%s", refs)
+	}
+
+	var denied int
+	for _, e := range events {
+		if _, ok := e.Error.(*ErrorRefDenied); ok {
+			denied++
+		}
+	}
+	if denied != 4 {
+		t.Errorf("got %d denied events, want 4: %+v", denied, events)
+	}
+}
+
+func TestInjectRefStatus(t *testing.T) {
+	denied := []receivePackCommand{{update: RefUpdate{Ref: "refs/heads/release"}}}
+	report := string(packetWrite("unpack ok\n")) + string(packetWrite("ok refs/heads/master\n")) + string(packetFlush())
+	want := string(packetWrite("unpack ok\n")) + string(packetWrite("ng refs/heads/release denied\n")) +
+		string(packetWrite("ok refs/heads/master\n")) + string(packetFlush())
+
+	// Plain report
+	var out bytes.Buffer
+	req := &receivePackRequest{capabilities: "report-status"}
+	if err := injectRefStatus(&out, strings.NewReader(report), req, denied); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	// Report split across side-band packets, after an empty pkt-line
+	out.Reset()
+	req = &receivePackRequest{capabilities: "report-status side-band-64k"}
+	input := "0004" + string(sidebandWrite(2, []byte("progress\n"))) + string(sidebandWrite(1, []byte(report[:6]))) +
+		string(sidebandWrite(1, []byte(report[6:]))) + string(packetFlush())
+	if err := injectRefStatus(&out, strings.NewReader(input), req, denied); err != nil {
+		t.Fatal(err)
+	}
+	var band1 []byte
+	rest := out.Bytes()
+	for len(rest) > 0 {
+		payload, raw, err := packetRead(bytes.NewReader(rest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[len(raw):]
+		if len(payload) > 0 && payload[0] == 1 {
+			band1 = append(band1, payload[1:]...)
+		}
+	}
+	if string(band1) != want {
+		t.Errorf("got %q, want %q", band1, want)
+	}
+}
@@ -0,0 +1,411 @@
+package githttp
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// CreateOptions contains the options of a new repository.
+type CreateOptions struct {
+	// Bare or with a working tree, GitOptions.NoBare decides if nil
+	Bare *bool `json:"bare,omitempty"`
+	// Branch that HEAD points to, GitOptions.DefaultBranch if empty
+	DefaultBranch string `json:"defaultBranch,omitempty"`
+	// Written to the description file, e.g. shown by gitweb
+	Description string `json:"description,omitempty"`
+	// User that creates the repository, passed to the Template
+	Creator *Identity `json:"-"`
+	// Skips the seed commit of the Template, e.g. for repositories that are created by a push
+	Empty bool `json:"empty,omitempty"`
+}
+
+// RepoInfo describes a repository.
+type RepoInfo struct {
+	Path          string    `json:"path"`
+	Bare          bool      `json:"bare"`
+	DefaultBranch string    `json:"defaultBranch"`
+	Description   string    `json:"description"`
+	State         RepoState `json:"state"`
+	// Local paths of the repository this one was forked from, and of its forks
+	Parent string   `json:"parent,omitempty"`
+	Forks  []string `json:"forks,omitempty"`
+}
+
+// configKeyRegex matches git config keys, i.e. section.key or section.subsection.key
+var configKeyRegex = regexp.MustCompile(`^[A-Za-z0-9-]+(\..+)?\.[A-Za-z][A-Za-z0-9-]*$`)
+
+// defaultDescription is written by git init
+const defaultDescription = "Unnamed repository; edit this file 'description' to name the repository."
+
+// CreateRepo creates a repository and returns its absolute local directory.
+func (g *gitContext) CreateRepo(repoPath string, options CreateOptions) (string, error) {
+	dir, err := g.managedDir(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if isRepoDir(dir) {
+		return "", ErrRepoExists
+	}
+	if options.DefaultBranch != "" {
+		if _, err := g.gitCommand(".", "check-ref-format", "--branch", options.DefaultBranch); err != nil {
+			return "", ErrInvalidBranch
+		}
+	}
+
+	if _, err := g.initRepo(dir, repoPath, options); err != nil {
+		return "", err
+	}
+	return dir, g.dropRedirect(repoPath)
+}
+
+// initRepo initializes a repository in a directory, from the template if there is one.
+func (g *gitContext) initRepo(dir string, repoPath string, options CreateOptions) (*gogit.Repository, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	bare := !g.options.NoBare
+	if options.Bare != nil {
+		bare = *options.Bare
+	}
+	branch := options.DefaultBranch
+	if branch == "" {
+		branch = g.options.DefaultBranch
+	}
+	if branch == "" {
+		branch = "master"
+	}
+	tmpl := g.options.Template
+
+	var repo *gogit.Repository
+	var err error
+	if tmpl != nil && tmpl.Dir != "" {
+		var templateDir string
+		templateDir, err = filepath.Abs(tmpl.Dir)
+		if err != nil {
+			return nil, err
+		}
+		args := []string{"init", "--quiet", "--template", templateDir}
+		if bare {
+			args = append(args, "--bare")
+		}
+		if _, err := g.gitCommand(dir, append(args, ".")...); err != nil {
+			return nil, err
+		}
+		repo, err = gogit.PlainOpen(dir)
+	} else {
+		repo, err = gogit.PlainInit(dir, bare)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.ReferenceName("refs/heads/"+branch))
+	if err := repo.Storer.SetReference(head); err != nil {
+		return nil, err
+	}
+	if options.Description != "" {
+		err := ioutil.WriteFile(filepath.Join(gitDir(dir), "description"), []byte(options.Description+"\n"), 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tmpl != nil && !options.Empty {
+		data := TemplateData{
+			Repo:        strings.Trim(repoPath, "/"),
+			Name:        path.Base(repoPath),
+			Branch:      branch,
+			Description: options.Description,
+			Year:        time.Now().Year(),
+		}
+		if options.Creator != nil {
+			data.Creator = options.Creator.Username
+		}
+		if err := tmpl.seed(repo, data); err != nil {
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+// DeleteRepo moves a repository into the trash if there is a TrashRetention, otherwise it deletes it irrevocably.
+// A REPO_DELETE event is fired in both cases.
+func (g *gitContext) DeleteRepo(repoPath string) error {
+	dir, err := g.managedDir(repoPath)
+	if err != nil {
+		return err
+	}
+	if !isRepoDir(dir) {
+		return gogit.ErrRepositoryNotExists
+	}
+	_, forks, err := readLinks(dir)
+	if err != nil {
+		return err
+	}
+	if len(forks) > 0 {
+		return ErrRepoHasForks
+	}
+
+	if g.options.TrashRetention > 0 {
+		err = g.trash(dir, repoPath)
+	} else {
+		err = g.removeRepo(dir)
+	}
+	if err != nil {
+		return err
+	}
+	g.event(Event{Type: REPO_DELETE, Dir: dir, Repo: strings.Trim(repoPath, "/")})
+	return nil
+}
+
+// removeRepo deletes a repository directory and unlinks it from its parent.
+func (g *gitContext) removeRepo(dir string) error {
+	parent, _, err := readLinks(dir)
+	if err != nil {
+		return err
+	}
+	link, err := g.link(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	g.removeEmptyParents(dir)
+	if parent != "" {
+		return g.unlinkFork(parent, link)
+	}
+	return nil
+}
+
+// MoveRepo renames or moves a repository.
+func (g *gitContext) MoveRepo(from string, to string) error {
+	fromDir, err := g.managedDir(from)
+	if err != nil {
+		return err
+	}
+	toDir, err := g.managedDir(to)
+	if err != nil {
+		return err
+	}
+	if !isRepoDir(fromDir) {
+		return gogit.ErrRepositoryNotExists
+	}
+	if _, err := os.Stat(toDir); err == nil {
+		return ErrRepoExists
+	}
+	// A repository can't be moved into itself
+	if strings.HasPrefix(toDir+string(filepath.Separator), fromDir+string(filepath.Separator)) {
+		return ErrInvalidRepoPath
+	}
+
+	if err := g.moveDir(fromDir, toDir); err != nil {
+		return err
+	}
+	return g.redirectMoved(from, to)
+}
+
+// ListRepos returns the paths of all repositories below the project root in lexical order.
+// The paths are local, i.e. they are only equal to the repository paths of requests
+// if the Preprocesser doesn't map them.
+func (g *gitContext) ListRepos() ([]string, error) {
+	root, err := g.root()
+	if err != nil {
+		return nil, err
+	}
+
+	repos := []string{}
+	err = filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || dir == root {
+			return nil
+		}
+		if dir == filepath.Join(root, metaDir) {
+			return filepath.SkipDir
+		}
+		if isRepoDir(dir) {
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return err
+			}
+			repos = append(repos, filepath.ToSlash(rel))
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
+
+// RepoInfo describes a repository.
+func (g *gitContext) RepoInfo(repoPath string) (RepoInfo, error) {
+	dir, err := g.managedDir(repoPath)
+	if err != nil {
+		return RepoInfo{}, err
+	}
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return RepoInfo{}, err
+	}
+
+	info := RepoInfo{Path: repoPath}
+	cfg, err := repo.Config()
+	if err != nil {
+		return RepoInfo{}, err
+	}
+	info.Bare = cfg.Core.IsBare
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return RepoInfo{}, err
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		info.DefaultBranch = strings.TrimPrefix(head.Target().String(), "refs/heads/")
+	}
+	description, err := ioutil.ReadFile(filepath.Join(gitDir(dir), "description"))
+	if err != nil && !os.IsNotExist(err) {
+		return RepoInfo{}, err
+	}
+	if d := strings.TrimSpace(string(description)); d != defaultDescription {
+		info.Description = d
+	}
+	info.State, err = g.repoState(dir)
+	if err != nil {
+		return RepoInfo{}, err
+	}
+	info.Parent, info.Forks, err = readLinks(dir)
+	if err != nil {
+		return RepoInfo{}, err
+	}
+	return info, nil
+}
+
+// RepoConfig returns the value of a git config key of a repository.
+func (g *gitContext) RepoConfig(repoPath string, key string) (string, error) {
+	dir, err := g.configDir(repoPath, key)
+	if err != nil {
+		return "", err
+	}
+	out, err := g.gitCommand(dir, "config", "--local", "--get", key)
+	if exitCode(err) == 1 {
+		return "", ErrConfigNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// SetRepoConfig sets a git config key of a repository.
+func (g *gitContext) SetRepoConfig(repoPath string, key string, value string) error {
+	dir, err := g.configDir(repoPath, key)
+	if err != nil {
+		return err
+	}
+	_, err = g.gitCommand(dir, "config", "--local", key, value)
+	return err
+}
+
+// UnsetRepoConfig removes a git config key of a repository.
+func (g *gitContext) UnsetRepoConfig(repoPath string, key string) error {
+	dir, err := g.configDir(repoPath, key)
+	if err != nil {
+		return err
+	}
+	_, err = g.gitCommand(dir, "config", "--local", "--unset-all", key)
+	if exitCode(err) == 5 {
+		return ErrConfigNotFound
+	}
+	return err
+}
+
+func (g *gitContext) configDir(repoPath string, key string) (string, error) {
+	if !configKeyRegex.MatchString(key) {
+		return "", ErrInvalidConfigKey
+	}
+	dir, err := g.managedDir(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if !isRepoDir(dir) {
+		return "", gogit.ErrRepositoryNotExists
+	}
+	return dir, nil
+}
+
+// managedDir resolves the absolute local directory of a repository that is managed via the API.
+// The project root itself is not a valid repository.
+func (g *gitContext) managedDir(repoPath string) (string, error) {
+	dir, err := g.RepoDir(repoPath)
+	if err != nil {
+		return "", err
+	}
+	root, err := g.root()
+	if err != nil {
+		return "", err
+	}
+	if dir == root {
+		return "", ErrInvalidRepoPath
+	}
+	return dir, nil
+}
+
+// removeEmptyParents removes the empty parent directories of a deleted repository up to the project root.
+func (g *gitContext) removeEmptyParents(dir string) {
+	root, err := g.root()
+	if err != nil {
+		return
+	}
+	for parent := filepath.Dir(dir); parent != root && strings.HasPrefix(parent, root); parent = filepath.Dir(parent) {
+		// Fails for directories that aren't empty
+		if os.Remove(parent) != nil {
+			return
+		}
+	}
+}
+
+// isRepoDir returns true if the directory contains a bare repository or a working tree.
+func isRepoDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return true
+	}
+	head, err := os.Stat(filepath.Join(dir, "HEAD"))
+	if err != nil || head.IsDir() {
+		return false
+	}
+	objects, err := os.Stat(filepath.Join(dir, "objects"))
+	return err == nil && objects.IsDir()
+}
+
+// gitDir returns the git directory of a bare repository or working tree.
+func gitDir(dir string) string {
+	if info, err := os.Stat(filepath.Join(dir, ".git")); err == nil && info.IsDir() {
+		return filepath.Join(dir, ".git")
+	}
+	return dir
+}
+
+// exitCode returns the exit code of a failed command, or -1 for other errors.
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(interface{ ExitStatus() int }); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
@@ -17,8 +17,11 @@ type HandlerReq struct {
 	w    http.ResponseWriter
 	r    *http.Request
 	RPC  string
+	Repo string
 	Dir  string
 	File string
+	// Git namespace of the repository within Dir, if any
+	Namespace string
 }
 
 // Routing regexes
@@ -34,6 +37,8 @@ var (
 	_getLooseObject    = regexp.MustCompile("(.*?)/objects/[0-9a-f]{2}/[0-9a-f]{38}$")
 	_getPackFile       = regexp.MustCompile("(.*?)/objects/pack/pack-[0-9a-f]{40}\\.pack$")
 	_getIdxFile        = regexp.MustCompile("(.*?)/objects/pack/pack-[0-9a-f]{40}\\.idx$")
+	_getBundle         = regexp.MustCompile("(.*?)/bundle$")
+	_importBundle      = regexp.MustCompile("(.*?)/bundle$")
 )
 
 func (g *gitContext) services() map[*regexp.Regexp]Service {
@@ -49,27 +54,36 @@ func (g *gitContext) services() map[*regexp.Regexp]Service {
 		_getLooseObject:    {"GET", g.getLooseObject, ""},
 		_getPackFile:       {"GET", g.getPackFile, ""},
 		_getIdxFile:        {"GET", g.getIdxFile, ""},
+		_getBundle:         {"GET", g.getBundle, "upload-pack"},
+		_importBundle:      {"POST", g.importBundle, "receive-pack"},
 	}
 }
 
 // getService return's the service corresponding to the
 // current http.Request's URL
-// as well as the name of the repo
-func (g *gitContext) getService(path string) (string, *Service) {
+// as well as the name of the repo.
+// Services of the same path are told apart by their method.
+func (g *gitContext) getService(method string, path string) (string, *Service) {
+	var repo string
+	var match *Service
 	for re, service := range g.services() {
 		if m := re.FindStringSubmatch(path); m != nil {
-			return m[1], &service
+			service := service
+			repo, match = m[1], &service
+			if service.Method == method {
+				break
+			}
 		}
 	}
 
-	// No match
-	return "", nil
+	// No match if nil
+	return repo, match
 }
 
 // Request handling function
 func (g *gitContext) requestHandler(w http.ResponseWriter, r *http.Request) {
 	// Get service for URL
-	repo, service := g.getService(r.URL.Path)
+	repo, service := g.getService(r.Method, r.URL.Path)
 
 	// No url match
 	if service == nil {
@@ -83,14 +97,36 @@ func (g *gitContext) requestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Renamed repository
+	if target, ok, err := g.redirectTarget(repo); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	} else if ok {
+		redirect(w, r, repo, target)
+		return
+	}
+
 	// RPC type
 	rpc := service.RPC
 
 	// Get specific file
 	file := strings.Replace(r.URL.Path, repo+"/", "", 1)
 
+	// Namespaced repositories are stored in another repository
+	physical, namespace, err := g.resolveNamespace(repo)
+	if err != nil {
+		renderNotFound(w)
+		return
+	}
+
+	// The dumb protocol would serve the refs and objects of all namespaces
+	if namespace != "" && rpc == "" && !_getInfoRefs.MatchString(r.URL.Path) {
+		renderNotFound(w)
+		return
+	}
+
 	// Resolve directory
-	dir, err := g.getGitDir(repo)
+	dir, err := g.getGitDir(r, physical, rpc)
 
 	// Repo not found on disk
 	if err != nil {
@@ -99,7 +135,20 @@ func (g *gitContext) requestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build request info for handler
-	hr := HandlerReq{w, r, rpc, dir, file}
+	hr := HandlerReq{w, r, rpc, repo, dir, file, namespace}
+
+	// The dumb protocol would serve the objects of hidden refs
+	if rpc == "" && !_getInfoRefs.MatchString(r.URL.Path) {
+		hidden, err := g.hiddenRefs(hr)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if len(hidden) > 0 {
+			renderNotFound(w)
+			return
+		}
+	}
 
 	// Call handler
 	if err := service.Handler(hr); err != nil {
@@ -18,6 +18,13 @@ type RpcReader struct {
 	// These events do not have the Dir field set.
 	Events []Event
 
+	// Maximum number of bytes to read, unlimited if zero.
+	// Reading beyond returns ErrSizeLimit.
+	MaxSize int64
+
+	// Number of bytes read so far.
+	Size int64
+
 	pktLineParser pktLineParser
 }
 
@@ -25,6 +32,10 @@ type RpcReader struct {
 func (r *RpcReader) Read(p []byte) (n int, err error) {
 	// Relay call
 	n, err = r.Reader.Read(p)
+	r.Size += int64(n)
+	if r.MaxSize > 0 && r.Size > r.MaxSize {
+		return 0, ErrSizeLimit
+	}
 
 	// Scan for events
 	if n > 0 {
@@ -0,0 +1,114 @@
+package githttp
+
+import (
+	"net/http"
+)
+
+// RepoState is the state of a repository, persisted as githttp.state in its git config.
+type RepoState string
+
+// Possible repository states
+const (
+	// Pushes are allowed
+	StateActive RepoState = "active"
+	// Pushes are rejected
+	StateReadOnly RepoState = "read-only"
+	// Pushes are rejected, the repository is no longer maintained
+	StateArchived RepoState = "archived"
+)
+
+// stateKey is the git config key of the repository state
+const stateKey = configSection + ".state"
+
+// Valid returns true for known states.
+func (s RepoState) Valid() bool {
+	return s == StateActive || s == StateReadOnly || s == StateArchived
+}
+
+// RepoState returns the state of a repository.
+func (g *gitContext) RepoState(repoPath string) (RepoState, error) {
+	dir, err := g.configDir(repoPath, stateKey)
+	if err != nil {
+		return "", err
+	}
+	return g.repoState(dir)
+}
+
+// SetRepoState changes the state of a repository and fires a REPO_STATE event.
+func (g *gitContext) SetRepoState(repoPath string, state RepoState, identity *Identity) error {
+	if !state.Valid() {
+		return ErrInvalidState
+	}
+	dir, err := g.configDir(repoPath, stateKey)
+	if err != nil {
+		return err
+	}
+	if state == StateActive {
+		_, err = g.gitCommand(dir, "config", "--local", "--unset-all", stateKey)
+		if exitCode(err) == 5 {
+			err = nil
+		}
+	} else {
+		_, err = g.gitCommand(dir, "config", "--local", stateKey, string(state))
+	}
+	if err != nil {
+		return err
+	}
+
+	g.event(Event{
+		Type:     REPO_STATE,
+		Dir:      dir,
+		State:    state,
+		Identity: identity,
+	})
+	return nil
+}
+
+// repoState reads the state of a repository directory, it is active if unset.
+func (g *gitContext) repoState(dir string) (RepoState, error) {
+	value, err := g.getGitConfig(stateKey, dir)
+	if exitCode(err) == 1 {
+		return StateActive, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return RepoState(value), nil
+}
+
+// checkPushState returns an *ErrorRepoState if the state of a repository doesn't allow pushes.
+func (g *gitContext) checkPushState(dir string) error {
+	state, err := g.repoState(dir)
+	if err != nil {
+		return err
+	}
+	if state != StateActive {
+		return &ErrorRepoState{Dir: dir, State: state}
+	}
+	return nil
+}
+
+// rejectPush fires an event for a push that the repository doesn't allow, e.g. due to its state,
+// and sends the reason to the client, either as ref advertisement or as result.
+func (g *gitContext) rejectPush(hr HandlerReq, pushErr error, advertisement bool) error {
+	e := Event{
+		Type:  PUSH,
+		Error: pushErr,
+	}
+	if stateErr, ok := pushErr.(*ErrorRepoState); ok {
+		e.State = stateErr.State
+	}
+	g.requestEvent(hr, e)
+
+	w := hr.w
+	if advertisement {
+		hdrNocache(w)
+		w.Header().Set("Content-Type", "application/x-git-receive-pack-advertisement")
+		w.WriteHeader(http.StatusOK)
+		w.Write(packetWrite("# service=git-receive-pack\n"))
+		w.Write(packetFlush())
+	} else {
+		w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+	}
+	return writeRemoteError(w, pushErr)
+}
@@ -0,0 +1,74 @@
+package githttp
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRepoState(t *testing.T) {
+	defer os.RemoveAll("./testdata/state")
+
+	var events []Event
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/state/server",
+		AutoCreate:  true,
+		ReceivePack: true,
+		UploadPack:  true,
+		EventHandler: func(ev Event) {
+			events = append(events, ev)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	if out, err := pushTestRepo("./testdata/state/client", server.URL+"/repo"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+
+	identity := &Identity{Username: "admin"}
+	if err := git.SetRepoState("repo", StateArchived, identity); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.SetRepoState("repo", "frozen", identity); err != ErrInvalidState {
+		t.Errorf("got %v, want ErrInvalidState", err)
+	}
+	if state, err := git.RepoState("repo"); err != nil || state != StateArchived {
+		t.Errorf("got %q, %v", state, err)
+	}
+	if out, _ := runGit("./testdata/state/server/repo", "config", "githttp.state"); out != "archived\n" {
+		t.Errorf("state isn't persisted in the config: %q", out)
+	}
+
+	// Pushes are rejected with a message, fetches still work
+	events = nil
+	out, err := runGit("./testdata/state/client", "push", server.URL+"/repo", "master:other")
+	if err == nil || !strings.Contains(out, "remote error: the repository is archived") {
+		t.Errorf("push to archived repository: %v\n%s", err, out)
+	}
+	if len(events) != 1 || events[0].Type != PUSH || events[0].State != StateArchived {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if _, ok := events[0].Error.(*ErrorRepoState); !ok {
+		t.Errorf("unexpected error %v", events[0].Error)
+	}
+	if out, err := runGit("./testdata/state", "clone", server.URL+"/repo", "clone"); err != nil {
+		t.Errorf("clone failed: %v\n%s", err, out)
+	}
+
+	// Active again
+	events = nil
+	if err := git.SetRepoState("repo", StateActive, identity); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != REPO_STATE || events[0].State != StateActive || events[0].Identity != identity {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if out, err := runGit("./testdata/state/client", "push", server.URL+"/repo", "master:other"); err != nil {
+		t.Errorf("push failed: %v\n%s", err, out)
+	}
+}
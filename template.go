@@ -0,0 +1,172 @@
+package githttp
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+type (
+	// Template initializes new repositories, created either by AutoCreate or CreateRepo.
+	Template struct {
+		// Template directory as in git init --template, e.g. with hooks, config and info/exclude
+		Dir string
+		// Files of the seed commit by path (e.g. README.md), rendered by text/template with TemplateData.
+		// New repositories are empty if there are none, as are the ones auto created by a push.
+		Files map[string]string
+		// Author and committer of the seed commit
+		AuthorName  string
+		AuthorEmail string
+		// Message of the seed commit, also rendered with TemplateData, "Initial commit" if empty
+		Message string
+	}
+
+	// TemplateData contains the variables of template files.
+	TemplateData struct {
+		// Path of the repository, e.g. team/app
+		Repo string
+		// Last element of the path, e.g. app
+		Name string
+		// Username of the creator, empty if anonymous
+		Creator     string
+		Branch      string
+		Description string
+		Year        int
+	}
+)
+
+// seed renders the template files and commits them to the branch.
+func (t *Template) seed(repo *gogit.Repository, data TemplateData) error {
+	if len(t.Files) == 0 {
+		return nil
+	}
+
+	files := map[string][]byte{}
+	for name, content := range t.Files {
+		rendered, err := render(name, content, data)
+		if err != nil {
+			return err
+		}
+		files[strings.Trim(path.Clean(name), "/")] = rendered
+	}
+	tree, err := writeTree(repo, files)
+	if err != nil {
+		return err
+	}
+
+	message := t.Message
+	if message == "" {
+		message = "Initial commit"
+	}
+	rendered, err := render("message", message, data)
+	if err != nil {
+		return err
+	}
+	signature := object.Signature{Name: t.AuthorName, Email: t.AuthorEmail, When: time.Now()}
+	commit, err := writeObject(repo, &object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message:   string(rendered),
+		TreeHash:  tree,
+	})
+	if err != nil {
+		return err
+	}
+
+	branch := plumbing.ReferenceName("refs/heads/" + data.Branch)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branch, commit)); err != nil {
+		return err
+	}
+
+	// Check the files out in working trees
+	if w, err := repo.Worktree(); err == nil {
+		return w.Reset(&gogit.ResetOptions{Commit: commit, Mode: gogit.HardReset})
+	} else if err != gogit.ErrIsBareRepository {
+		return err
+	}
+	return nil
+}
+
+func render(name string, content string, data TemplateData) ([]byte, error) {
+	tmpl, err := template.New(name).Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeTree stores the blobs and trees of files by their slash separated paths and returns the root tree.
+func writeTree(repo *gogit.Repository, files map[string][]byte) (plumbing.Hash, error) {
+	var entries []object.TreeEntry
+	subtrees := map[string]map[string][]byte{}
+	for name, content := range files {
+		if i := strings.Index(name, "/"); i >= 0 {
+			dir := name[:i]
+			if subtrees[dir] == nil {
+				subtrees[dir] = map[string][]byte{}
+			}
+			subtrees[dir][name[i+1:]] = content
+			continue
+		}
+
+		blob := repo.Storer.NewEncodedObject()
+		blob.SetType(plumbing.BlobObject)
+		w, err := blob.Writer()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if _, err := w.Write(content); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if err := w.Close(); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		hash, err := repo.Storer.SetEncodedObject(blob)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: hash})
+	}
+	for dir, subfiles := range subtrees {
+		hash, err := writeTree(repo, subfiles)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: hash})
+	}
+
+	// Git sorts directories as if their names ended with a slash
+	sortName := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortName(entries[i]) < sortName(entries[j])
+	})
+	return writeObject(repo, &object.Tree{Entries: entries})
+}
+
+// writeObject stores an encodable object.
+func writeObject(repo *gogit.Repository, o interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	obj := repo.Storer.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(obj)
+}
@@ -0,0 +1,139 @@
+package githttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	defer os.RemoveAll("./testdata/template")
+
+	// Template directory with a hook and an exclude file
+	if err := os.MkdirAll("./testdata/template/tmpl/hooks", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("./testdata/template/tmpl/info", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("./testdata/template/tmpl/hooks/post-update", []byte("#!/bin/sh\nexec git update-server-info\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("./testdata/template/tmpl/info/exclude", []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot:   "./testdata/template/server",
+		AutoCreate:    true,
+		UploadPack:    true,
+		ReceivePack:   true,
+		DefaultBranch: "main",
+		Template: &Template{
+			Dir: "./testdata/template/tmpl",
+			Files: map[string]string{
+				"README.md":     "# {{.Name}}\n\nCreated by {{.Creator}} in {{.Repo}}\n",
+				".gitignore":    "*.log\n",
+				"docs/LICENSE":  "Copyright (c) {{.Year}} {{.Creator}}\n",
+				"docs/a/b/c.md": "{{.Branch}}\n",
+			},
+			AuthorName:  "githttp",
+			AuthorEmail: "githttp@example.org",
+			Message:     "Create {{.Repo}}",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		git.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), &Identity{Username: "jane"})))
+	}))
+	defer server.Close()
+
+	// Auto created repositories are seeded
+	if out, err := runGit("./testdata/template", "clone", server.URL+"/team/app", "clone"); err != nil {
+		t.Fatalf("clone failed: %v\n%s", err, out)
+	}
+	readme, err := ioutil.ReadFile("./testdata/template/clone/README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(readme) != "# app\n\nCreated by jane in team/app\n" {
+		t.Errorf("unexpected README:\n%s", readme)
+	}
+	if out, _ := runGit("./testdata/template/clone", "log", "--format=%an %s", "main"); out != "githttp Create team/app\n" {
+		t.Errorf("unexpected log %q", out)
+	}
+	if out, _ := runGit("./testdata/template/clone", "ls-files"); out != ".gitignore\nREADME.md\ndocs/LICENSE\ndocs/a/b/c.md\n" {
+		t.Errorf("unexpected files %q", out)
+	}
+	if out, err := runGit("./testdata/template/clone", "fsck", "--strict"); err != nil {
+		t.Errorf("fsck failed: %v\n%s", err, out)
+	}
+
+	// The template directory was copied
+	if _, err := os.Stat("./testdata/template/server/team/app/hooks/post-update"); err != nil {
+		t.Error(err)
+	}
+
+	// Repositories created by a push aren't seeded, so that the push isn't rejected
+	if out, err := pushTestRepo("./testdata/template/push", server.URL+"/team/pushed", "master:main"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	pushed, _ := runGit("./testdata/template/push", "rev-parse", "master")
+	if out, _ := runGit("./testdata/template/server/team/pushed", "rev-parse", "main"); out != pushed {
+		t.Errorf("main is %q, want %q", out, pushed)
+	}
+	if _, err := os.Stat("./testdata/template/server/team/pushed/hooks/post-update"); err != nil {
+		t.Error(err)
+	}
+
+	// Working trees of repositories created by the API are checked out
+	bare := false
+	dir, err := git.CreateRepo("team/work", CreateOptions{Bare: &bare, Creator: &Identity{Username: "john"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	license, err := ioutil.ReadFile(filepath.Join(dir, "docs/LICENSE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(license), " john\n") {
+		t.Errorf("unexpected LICENSE:\n%s", license)
+	}
+	if out, _ := runGit(dir, "status", "--porcelain"); out != "" {
+		t.Errorf("working tree isn't clean:\n%s", out)
+	}
+}
+
+func TestTemplateInitFailure(t *testing.T) {
+	defer os.RemoveAll("./testdata/template-failure")
+
+	// A git binary that succeeds without initializing anything
+	if err := os.MkdirAll("./testdata/template-failure/tmpl", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("./testdata/template-failure/git", []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	gitBin, err := filepath.Abs("./testdata/template-failure/git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot: "./testdata/template-failure/server",
+		GitBinPath:  gitBin,
+		Template:    &Template{Dir: "./testdata/template-failure/tmpl"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git.CreateRepo("team/app", CreateOptions{}); err == nil {
+		t.Error("creating a repository that can't be opened succeeded")
+	}
+}
@@ -0,0 +1,229 @@
+package githttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Trash layout below the metaDir, each deleted repository gets a directory with its metadata and the repository
+const (
+	trashDir      = "trash"
+	trashInfoFile = "trash.json"
+	trashRepoDir  = "repo"
+)
+
+// trashPurgeInterval is the time between two purges of RunTrashPurge
+const trashPurgeInterval = time.Hour
+
+// TrashedRepo is a deleted repository that can be restored until it expires.
+type TrashedRepo struct {
+	ID      string    `json:"id"`
+	Path    string    `json:"path"`
+	Deleted time.Time `json:"deleted"`
+	Expires time.Time `json:"expires"`
+}
+
+// TrashedRepos returns the repositories in the trash, the most recently deleted first.
+func (g *gitContext) TrashedRepos() ([]TrashedRepo, error) {
+	dir, err := g.trashDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	trashed := []TrashedRepo{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		repo, err := readTrashInfo(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		trashed = append(trashed, repo)
+	}
+	sort.Slice(trashed, func(i, j int) bool {
+		return trashed[i].Deleted.After(trashed[j].Deleted)
+	})
+	return trashed, nil
+}
+
+// RestoreRepo moves a repository from the trash back to its path and returns the path.
+func (g *gitContext) RestoreRepo(id string) (string, error) {
+	entryDir, repo, err := g.trashEntry(id)
+	if err != nil {
+		return "", err
+	}
+	dir, err := g.managedDir(repo.Path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return "", ErrRepoExists
+	}
+
+	if err := g.moveDir(filepath.Join(entryDir, trashRepoDir), dir); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(entryDir); err != nil {
+		return "", err
+	}
+	g.event(Event{Type: REPO_RESTORE, Dir: dir, Repo: repo.Path})
+	return repo.Path, g.dropRedirect(repo.Path)
+}
+
+// PurgeRepo deletes a repository from the trash irrevocably.
+func (g *gitContext) PurgeRepo(id string) error {
+	entryDir, repo, err := g.trashEntry(id)
+	if err != nil {
+		return err
+	}
+	return g.purge(entryDir, repo)
+}
+
+// PurgeTrash deletes the repositories whose retention expired from the trash.
+func (g *gitContext) PurgeTrash() error {
+	trashed, err := g.TrashedRepos()
+	if err != nil {
+		return err
+	}
+	dir, err := g.trashDir()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, repo := range trashed {
+		if repo.Expires.After(now) {
+			continue
+		}
+		if err := g.purge(filepath.Join(dir, repo.ID), repo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunTrashPurge purges the expired repositories from the trash every hour until the context is done.
+func (g *gitContext) RunTrashPurge(ctx context.Context) error {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		if err := g.PurgeTrash(); err != nil {
+			g.event(Event{Type: REPO_PURGE, Error: err})
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// trash moves a repository into the trash, where it's neither served nor listed.
+func (g *gitContext) trash(dir string, repoPath string) error {
+	trash, err := g.trashDir()
+	if err != nil {
+		return err
+	}
+	id, err := trashID()
+	if err != nil {
+		return err
+	}
+	entryDir := filepath.Join(trash, id)
+	if err := os.MkdirAll(entryDir, os.ModePerm); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	repo := TrashedRepo{
+		ID:      id,
+		Path:    strings.Trim(repoPath, "/"),
+		Deleted: now,
+		Expires: now.Add(g.options.TrashRetention),
+	}
+	data, err := json.MarshalIndent(repo, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(entryDir, trashInfoFile), data, 0644); err != nil {
+		return err
+	}
+	return g.moveDir(dir, filepath.Join(entryDir, trashRepoDir))
+}
+
+// purge deletes a repository of the trash and fires a REPO_PURGE event.
+func (g *gitContext) purge(entryDir string, repo TrashedRepo) error {
+	err := g.removeRepo(filepath.Join(entryDir, trashRepoDir))
+	if err == nil {
+		err = os.RemoveAll(entryDir)
+	}
+	g.event(Event{Type: REPO_PURGE, Dir: entryDir, Repo: repo.Path, Error: err})
+	return err
+}
+
+// moveDir moves a repository directory and updates the links between forks and parents,
+// which also keeps the objects of trashed forks alive.
+func (g *gitContext) moveDir(fromDir string, toDir string) error {
+	if err := os.MkdirAll(filepath.Dir(toDir), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(fromDir, toDir); err != nil {
+		return err
+	}
+	g.removeEmptyParents(fromDir)
+	return g.relinkMoved(fromDir, toDir)
+}
+
+func (g *gitContext) trashDir() (string, error) {
+	root, err := g.root()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, metaDir, trashDir), nil
+}
+
+// trashEntry returns the directory and metadata of a repository in the trash.
+func (g *gitContext) trashEntry(id string) (string, TrashedRepo, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", TrashedRepo{}, ErrTrashNotFound
+	}
+	trash, err := g.trashDir()
+	if err != nil {
+		return "", TrashedRepo{}, err
+	}
+	entryDir := filepath.Join(trash, id)
+	repo, err := readTrashInfo(entryDir)
+	if os.IsNotExist(err) {
+		return "", TrashedRepo{}, ErrTrashNotFound
+	}
+	return entryDir, repo, err
+}
+
+func readTrashInfo(entryDir string) (TrashedRepo, error) {
+	var repo TrashedRepo
+	data, err := ioutil.ReadFile(filepath.Join(entryDir, trashInfoFile))
+	if err != nil {
+		return repo, err
+	}
+	err = json.Unmarshal(data, &repo)
+	return repo, err
+}
+
+// trashID returns a unique id that sorts by time.
+func trashID() (string, error) {
+	random := make([]byte, 4)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(random), nil
+}
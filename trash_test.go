@@ -0,0 +1,150 @@
+package githttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	defer os.RemoveAll("./testdata/trash")
+
+	var events []Event
+	git, err := NewGitContext(GitOptions{
+		ProjectRoot:    "./testdata/trash/server",
+		UploadPack:     true,
+		ReceivePack:    true,
+		TrashRetention: time.Hour,
+		EventHandler: func(ev Event) {
+			switch ev.Type {
+			case REPO_DELETE, REPO_RESTORE, REPO_PURGE:
+				events = append(events, ev)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(git)
+	defer server.Close()
+
+	if _, err := git.CreateRepo("team/app", CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := pushTestRepo("./testdata/trash/client", server.URL+"/team/app"); err != nil {
+		t.Fatalf("push failed: %v\n%s", err, out)
+	}
+	if _, err := git.ForkRepo("team/app", "team/fork"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Repositories with forks can't be deleted, trashed forks are still linked to their parent
+	if err := git.DeleteRepo("team/app"); err != ErrRepoHasForks {
+		t.Errorf("got %v, want ErrRepoHasForks", err)
+	}
+	if err := git.DeleteRepo("team/fork"); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.DeleteRepo("team/app"); err != ErrRepoHasForks {
+		t.Errorf("got %v, want ErrRepoHasForks", err)
+	}
+	if _, err := os.Stat("./testdata/trash/server/team/fork"); !os.IsNotExist(err) {
+		t.Errorf("fork wasn't moved: %v", err)
+	}
+	if repos, err := git.ListRepos(); err != nil || len(repos) != 1 || repos[0] != "team/app" {
+		t.Errorf("unexpected repos %v: %v", repos, err)
+	}
+	if out, err := runGit("./testdata/trash", "clone", server.URL+"/team/fork", "deleted"); err == nil {
+		t.Errorf("clone of trashed repository succeeded:\n%s", out)
+	}
+	if out, err := runGit("./testdata/trash", "clone", server.URL+"/.githttp/trash", "trash"); err == nil {
+		t.Errorf("clone of the trash succeeded:\n%s", out)
+	}
+
+	trashed, err := git.TrashedRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 1 || trashed[0].Path != "team/fork" || trashed[0].Expires.Sub(trashed[0].Deleted) != time.Hour {
+		t.Fatalf("unexpected trash %+v", trashed)
+	}
+	if _, err := git.RestoreRepo("missing"); err != ErrTrashNotFound {
+		t.Errorf("got %v, want ErrTrashNotFound", err)
+	}
+	if _, err := git.RestoreRepo("../../team"); err != ErrTrashNotFound {
+		t.Errorf("got %v, want ErrTrashNotFound", err)
+	}
+
+	// Nothing expired yet
+	if err := git.PurgeTrash(); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.RestoreRepo(trashed[0].ID)
+	if err != nil || repo != "team/fork" {
+		t.Fatalf("restore returned %q: %v", repo, err)
+	}
+	if out, err := runGit("./testdata/trash", "clone", server.URL+"/team/fork", "restored"); err != nil {
+		t.Fatalf("clone failed: %v\n%s", err, out)
+	}
+	info, err := git.RepoInfo("team/fork")
+	if err != nil || info.Parent != "team/app" {
+		t.Errorf("unexpected parent %q: %v", info.Parent, err)
+	}
+	if trashed, err := git.TrashedRepos(); err != nil || len(trashed) != 0 {
+		t.Errorf("unexpected trash %+v: %v", trashed, err)
+	}
+
+	// Expired repositories are purged and their parents unlinked
+	if err := git.DeleteRepo("team/fork"); err != nil {
+		t.Fatal(err)
+	}
+	if trashed, err = git.TrashedRepos(); err != nil || len(trashed) != 1 {
+		t.Fatalf("unexpected trash %+v: %v", trashed, err)
+	}
+	expired := trashed[0]
+	expired.Expires = time.Now().Add(-time.Minute)
+	data, err := json.Marshal(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join("./testdata/trash/server", metaDir, trashDir, expired.ID, trashInfoFile), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := git.PurgeTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if trashed, err := git.TrashedRepos(); err != nil || len(trashed) != 0 {
+		t.Errorf("unexpected trash %+v: %v", trashed, err)
+	}
+	// Deleted right away without retention
+	git.(*gitContext).options.TrashRetention = 0
+	if err := git.DeleteRepo("team/app"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := filepath.Glob("./testdata/trash/server/.githttp/trash/*"); err != nil || len(entries) != 0 {
+		t.Errorf("unexpected trash entries %v: %v", entries, err)
+	}
+
+	var types []EventType
+	for _, ev := range events {
+		if ev.Error != nil {
+			t.Errorf("unexpected error %v", ev.Error)
+		}
+		types = append(types, ev.Type)
+	}
+	want := []EventType{REPO_DELETE, REPO_RESTORE, REPO_DELETE, REPO_PURGE, REPO_DELETE}
+	if len(types) != len(want) {
+		t.Fatalf("got events %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("got events %v, want %v", types, want)
+			break
+		}
+	}
+}
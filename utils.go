@@ -76,6 +76,45 @@ func packetWrite(str string) []byte {
 	return []byte(s + str)
 }
 
+// packetRead reads a single pkt-line and returns its payload
+// as well as the raw bytes read. A flush-pkt has a nil payload.
+func packetRead(r io.Reader) (payload []byte, raw []byte, err error) {
+	header := make([]byte, pktLenSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	pktLen, err := parsePktLen(header)
+	if err != nil || pktLen == 0 {
+		return nil, header, err
+	}
+	raw = make([]byte, pktLen)
+	copy(raw, header)
+	if _, err := io.ReadFull(r, raw[pktLenSize:]); err != nil {
+		return nil, nil, err
+	}
+	return raw[pktLenSize:], raw, nil
+}
+
+// packetReadSection reads pkt-lines up to and including the next flush-pkt.
+func packetReadSection(r io.Reader) (lines []string, raw []byte, err error) {
+	for {
+		payload, pkt, err := packetRead(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw = append(raw, pkt...)
+		if payload == nil {
+			return lines, raw, nil
+		}
+		lines = append(lines, string(payload))
+	}
+}
+
+// sidebandWrite wraps data into a side-band pkt-line of the given band.
+func sidebandWrite(band byte, data []byte) []byte {
+	return packetWrite(string(band) + string(data))
+}
+
 // Header writing functions
 
 func hdrNocache(w http.ResponseWriter) {